@@ -3,12 +3,17 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strconv"
 
 	"github.com/chongyangshi/Order/proto"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
+// dryRunEnv overrides dry_run in config if set
+const dryRunEnv = "ORDER_DRY_RUN"
+
 // CurrentVersion represents the current version number of Order
 const CurrentVersion = 0.1
 
@@ -19,13 +24,13 @@ func IsVersionCompatible(version float64) bool {
 
 // Parse config from a YAML ConfigMap (which Order can use to manage Order!)
 // specifying which Secrets and ConfigMaps affect which resource controllers,
-// and what cooldown they all have for rolling restarts. We won't attempt to
-// parse pod controllers (Deployments, DaemonSets, ReplicaSets, StatefulSets,
-// CronJobs/Jobs) as determining mounting and reference relationships can
-// be challenging in the Kubernetes API schema, and globally restarting all
-// resources at once when any referenced or mounted resource they share
-// changes may not necessarily be the desired behaviour, especially in a
-// large cluster.
+// and what cooldown they all have for rolling restarts. By default, we won't
+// treat every Secret and ConfigMap referenced by pod controllers (Deployments,
+// DaemonSets, ReplicaSets, StatefulSets, CronJobs/Jobs) as managed, as globally
+// restarting all resources at once when any referenced or mounted resource
+// they share changes may not necessarily be the desired behaviour, especially
+// in a large cluster. This can be opted into with auto_discover, globally or
+// for specific namespaces.
 
 // Config is a global state storing the runtime config, which is read only.
 // However, Order can monitor and restart itself if its config changes in
@@ -42,7 +47,7 @@ func LoadConfig(configPath string) error {
 
 	Config = &proto.OrderConfig{}
 	err = yaml.Unmarshal(configBytes, &Config)
-	if err == nil {
+	if err != nil {
 		return fmt.Errorf("Got unmarshaling config from %s: %v", configPath, err)
 	}
 
@@ -51,5 +56,13 @@ func LoadConfig(configPath string) error {
 		return fmt.Errorf("Got parsing config from %s: %v", configPath, err)
 	}
 
+	if value := os.Getenv(dryRunEnv); value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Got parsing %s=%s: %v", dryRunEnv, value, err)
+		}
+		Config.DryRun = dryRun
+	}
+
 	return nil
 }
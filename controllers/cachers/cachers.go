@@ -6,34 +6,112 @@ import (
 
 	"github.com/chongyangshi/Order/config"
 	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+
+	"github.com/chongyangshi/Order/proto"
 )
 
 var (
-	dsController     *daemonSetsCacheController
-	deployController *deploymentsCacheController
-	jobsController   *jobsCacheController
-	stsController    *statefulSetsCacheController
+	dsController       *daemonSetsCacheController
+	deployController   *deploymentsCacheController
+	jobsController     *jobsCacheController
+	stsController      *statefulSetsCacheController
+	rsController       *replicaSetsCacheController
+	rcController       *replicationControllersCacheController
+	podsController     *podsCacheController
+	cronJobsController *cronJobsCacheController
+	pdbController      *podDisruptionBudgetsCacheController
+	customControllers  []*customCacheController
 )
 
+// CustomPodController is a custom resource of a kind configured in custom_pod_controllers,
+// along with the pod template it embeds.
+type CustomPodController struct {
+	Object      *unstructured.Unstructured
+	Kind        *proto.CustomPodController
+	Resource    schema.GroupVersionResource
+	PodTemplate corev1.PodTemplateSpec
+}
+
 // Init launches a series of pod controllers which may run pods mounting resources managed by
 // Order. They provide an eventually consistent cache we use to determine whether a rolling
 // restart is required in response to changes to a managed resource.
-func Init(clientSet kubernetes.Interface, stopChan chan struct{}, resyncInterval time.Duration) {
+func Init(clientSet kubernetes.Interface, dynamicClient dynamic.Interface, stopChan chan struct{}, resyncInterval time.Duration) {
 	dsController = newDaemonSetsController(clientSet, resyncInterval)
 	go dsController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "DaemonSets", dsController.synced)
 
 	deployController = newDeploymentsController(clientSet, resyncInterval)
 	go deployController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "Deployments", deployController.synced)
 
 	jobsController = newJobsController(clientSet, resyncInterval)
 	go jobsController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "Jobs", jobsController.synced)
 
 	stsController = newStatefulSetsController(clientSet, resyncInterval)
 	go stsController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "StatefulSets", stsController.synced)
+
+	rsController = newReplicaSetsController(clientSet, resyncInterval)
+	go rsController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "ReplicaSets", rsController.synced)
+
+	rcController = newReplicationControllersController(clientSet, resyncInterval)
+	go rcController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "ReplicationControllers", rcController.synced)
+
+	podsController = newPodsController(clientSet, resyncInterval)
+	go podsController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "Pods", podsController.synced)
+
+	cronJobsController = newCronJobsController(clientSet, resyncInterval)
+	go cronJobsController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "CronJobs", cronJobsController.synced)
+
+	pdbController = newPodDisruptionBudgetsController(clientSet, resyncInterval)
+	go pdbController.run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleCache, "PodDisruptionBudgets", pdbController.synced)
+
+	// Custom pod controllers are only known by kind in config, so we look up the API
+	// resources serving them before watching them.
+	if len(config.Config.CustomPodControllers) > 0 {
+		groupResources, err := restmapper.GetAPIGroupResources(clientSet.Discovery())
+		if err != nil {
+			logging.Fatal("Error discovering API resources for custom pod controllers: %v", err)
+		}
+		mapper := restmapper.NewDiscoveryRESTMapper(groupResources)
+
+		for _, kind := range config.Config.CustomPodControllers {
+			if kind == nil {
+				continue
+			}
+
+			gvk := kind.GroupVersionKind()
+			mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				logging.Fatal("Error finding API resource for custom pod controller %s: %v", gvk.String(), err)
+			}
+
+			controller := newCustomController(dynamicClient, kind, mapping.Resource, resyncInterval)
+			go controller.run(stopChan)
+			metrics.RegisterInformer(metrics.InformerRoleCache, kind.Kind, controller.synced)
+			customControllers = append(customControllers, controller)
+		}
+	}
 
 	// Block until all controllers have synced
 	for {
@@ -51,6 +129,28 @@ func Init(clientSet kubernetes.Interface, stopChan chan struct{}, resyncInterval
 		case !stsController.synced():
 			logging.Log("StatefulSets controller not yet synced")
 			allSynced = false
+		case !rsController.synced():
+			logging.Log("ReplicaSets controller not yet synced")
+			allSynced = false
+		case !rcController.synced():
+			logging.Log("ReplicationControllers controller not yet synced")
+			allSynced = false
+		case !podsController.synced():
+			logging.Log("Pods controller not yet synced")
+			allSynced = false
+		case !cronJobsController.synced():
+			logging.Log("CronJobs controller not yet synced")
+			allSynced = false
+		case !pdbController.synced():
+			logging.Log("PodDisruptionBudgets controller not yet synced")
+			allSynced = false
+		}
+
+		for _, controller := range customControllers {
+			if allSynced && !controller.synced() {
+				logging.Log("%s controller not yet synced", controller.kind.Kind)
+				allSynced = false
+			}
 		}
 
 		if allSynced {
@@ -82,7 +182,7 @@ func GetDaemonSets() ([]*appsv1.DaemonSet, error) {
 			continue
 		}
 
-		if inConfigNamespaces(ds.Namespace) {
+		if InConfigNamespaces(ds.Namespace) {
 			results = append(results, ds)
 		}
 	}
@@ -109,7 +209,7 @@ func GetDeployments() ([]*appsv1.Deployment, error) {
 			continue
 		}
 
-		if inConfigNamespaces(deploy.Namespace) {
+		if InConfigNamespaces(deploy.Namespace) {
 			results = append(results, deploy)
 		}
 	}
@@ -118,7 +218,7 @@ func GetDeployments() ([]*appsv1.Deployment, error) {
 }
 
 // GetJobs returns all Jobs currently in controller cache whose namespace
-// we care about as set in config.
+// we care about as set in config, including those spawned by CronJobs.
 func GetJobs() ([]*batchv1.Job, error) {
 	if jobsController == nil {
 		return nil, fmt.Errorf("Jobs controller is not yet initialised")
@@ -136,7 +236,61 @@ func GetJobs() ([]*batchv1.Job, error) {
 			continue
 		}
 
-		if inConfigNamespaces(job.Namespace) {
+		if InConfigNamespaces(job.Namespace) {
+			results = append(results, job)
+		}
+	}
+
+	return results, nil
+}
+
+// GetCronJobs returns all CronJobs currently in controller cache whose namespace
+// we care about as set in config.
+func GetCronJobs() ([]*batchv1.CronJob, error) {
+	if cronJobsController == nil {
+		return nil, fmt.Errorf("CronJobs controller is not yet initialised")
+	}
+
+	cronJobsControllers, err := cronJobsController.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*batchv1.CronJob
+	for _, cronJob := range cronJobsControllers {
+		if cronJob == nil {
+			// Should never happen
+			continue
+		}
+
+		if InConfigNamespaces(cronJob.Namespace) {
+			results = append(results, cronJob)
+		}
+	}
+
+	return results, nil
+}
+
+// GetJobsControlledBy returns all Jobs currently in controller cache which are controlled
+// by the owner with the UID in the namespace, such as Jobs spawned by a CronJob.
+func GetJobsControlledBy(namespace string, uid types.UID) ([]*batchv1.Job, error) {
+	if jobsController == nil {
+		return nil, fmt.Errorf("Jobs controller is not yet initialised")
+	}
+
+	jobs, err := jobsController.lister.Jobs(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*batchv1.Job
+	for _, job := range jobs {
+		if job == nil {
+			// Should never happen
+			continue
+		}
+
+		if owner := metav1.GetControllerOf(job); owner != nil && owner.UID == uid {
 			results = append(results, job)
 		}
 	}
@@ -163,7 +317,7 @@ func GetStatefulSets() ([]*appsv1.StatefulSet, error) {
 			continue
 		}
 
-		if inConfigNamespaces(sts.Namespace) {
+		if InConfigNamespaces(sts.Namespace) {
 			results = append(results, sts)
 		}
 	}
@@ -171,7 +325,216 @@ func GetStatefulSets() ([]*appsv1.StatefulSet, error) {
 	return results, nil
 }
 
-func inConfigNamespaces(namespace string) bool {
+// GetReplicaSets returns all standalone ReplicaSets currently in controller cache whose
+// namespace we care about as set in config. ReplicaSets controlled by Deployments are
+// excluded, as they are restarted through their Deployments.
+func GetReplicaSets() ([]*appsv1.ReplicaSet, error) {
+	if rsController == nil {
+		return nil, fmt.Errorf("ReplicaSets controller is not yet initialised")
+	}
+
+	rsControllers, err := rsController.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*appsv1.ReplicaSet
+	for _, rs := range rsControllers {
+		if rs == nil {
+			// Should never happen
+			continue
+		}
+
+		if metav1.GetControllerOf(rs) != nil {
+			continue
+		}
+
+		if InConfigNamespaces(rs.Namespace) {
+			results = append(results, rs)
+		}
+	}
+
+	return results, nil
+}
+
+// GetReplicationControllers returns all ReplicationControllers currently in controller
+// cache whose namespace we care about as set in config.
+func GetReplicationControllers() ([]*corev1.ReplicationController, error) {
+	if rcController == nil {
+		return nil, fmt.Errorf("ReplicationControllers controller is not yet initialised")
+	}
+
+	rcControllers, err := rcController.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*corev1.ReplicationController
+	for _, rc := range rcControllers {
+		if rc == nil {
+			// Should never happen
+			continue
+		}
+
+		if InConfigNamespaces(rc.Namespace) {
+			results = append(results, rc)
+		}
+	}
+
+	return results, nil
+}
+
+// GetBarePods returns all Pods without any owner currently in controller cache whose
+// namespace we care about as set in config.
+func GetBarePods() ([]*corev1.Pod, error) {
+	if podsController == nil {
+		return nil, fmt.Errorf("Pods controller is not yet initialised")
+	}
+
+	pods, err := podsController.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*corev1.Pod
+	for _, pod := range pods {
+		if pod == nil {
+			// Should never happen
+			continue
+		}
+
+		if len(pod.OwnerReferences) > 0 {
+			continue
+		}
+
+		if InConfigNamespaces(pod.Namespace) {
+			results = append(results, pod)
+		}
+	}
+
+	return results, nil
+}
+
+// GetPodsBySelector returns all Pods currently in controller cache in the namespace which
+// match a label selector, such as that of a pod controller.
+func GetPodsBySelector(namespace string, selector labels.Selector) ([]*corev1.Pod, error) {
+	if podsController == nil {
+		return nil, fmt.Errorf("Pods controller is not yet initialised")
+	}
+
+	return podsController.lister.Pods(namespace).List(selector)
+}
+
+// GetPodDisruptionBudgetsSelecting returns all PodDisruptionBudgets currently in
+// controller cache in the namespace, whose selectors match the pod labels. As in
+// policy/v1, a PodDisruptionBudget with an empty selector matches all pods in its
+// namespace, and one without a selector matches none.
+func GetPodDisruptionBudgetsSelecting(namespace string, podLabels labels.Set) ([]*policyv1.PodDisruptionBudget, error) {
+	if pdbController == nil {
+		return nil, fmt.Errorf("PodDisruptionBudgets controller is not yet initialised")
+	}
+
+	pdbs, err := pdbController.lister.PodDisruptionBudgets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*policyv1.PodDisruptionBudget
+	for _, pdb := range pdbs {
+		if pdb == nil || pdb.Spec.Selector == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			logging.Log("Ignoring PodDisruptionBudget %s/%s with invalid selector: %v", pdb.Namespace, pdb.Name, err)
+			continue
+		}
+
+		if selector.Matches(podLabels) {
+			results = append(results, pdb)
+		}
+	}
+
+	return results, nil
+}
+
+// GetPodsControlledBy returns all Pods currently in controller cache which are controlled
+// by the owner with the UID in the namespace.
+func GetPodsControlledBy(namespace string, uid types.UID) ([]*corev1.Pod, error) {
+	if podsController == nil {
+		return nil, fmt.Errorf("Pods controller is not yet initialised")
+	}
+
+	pods, err := podsController.lister.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*corev1.Pod
+	for _, pod := range pods {
+		if pod == nil {
+			// Should never happen
+			continue
+		}
+
+		if owner := metav1.GetControllerOf(pod); owner != nil && owner.UID == uid {
+			results = append(results, pod)
+		}
+	}
+
+	return results, nil
+}
+
+// GetCustomPodControllers returns all custom resources of kinds configured as custom pod
+// controllers currently in controller cache, whose namespace we care about as set in
+// config. Custom resources whose pod template can't be read are skipped.
+func GetCustomPodControllers() ([]*CustomPodController, error) {
+	var results []*CustomPodController
+	for _, controller := range customControllers {
+		objects, err := controller.lister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range objects {
+			u, ok := object.(*unstructured.Unstructured)
+			if !ok || u == nil {
+				// Should never happen
+				continue
+			}
+
+			if !InConfigNamespaces(u.GetNamespace()) {
+				continue
+			}
+
+			template, found, err := unstructured.NestedMap(u.Object, controller.kind.XXXParsedPodTemplatePath...)
+			if err != nil || !found {
+				logging.Debug("Cannot find pod template of %s %s/%s at %s: %v", controller.kind.Kind, u.GetNamespace(), u.GetName(), controller.kind.PodTemplatePath, err)
+				continue
+			}
+
+			result := &CustomPodController{
+				Object:   u,
+				Kind:     controller.kind,
+				Resource: controller.resource,
+			}
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(template, &result.PodTemplate)
+			if err != nil {
+				logging.Debug("Cannot read pod template of %s %s/%s: %v", controller.kind.Kind, u.GetNamespace(), u.GetName(), err)
+				continue
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// InConfigNamespaces returns whether Order acts on pod controllers in the namespace, as
+// set in config.
+func InConfigNamespaces(namespace string) bool {
 	if config.Config == nil {
 		logging.Fatal("Config namespaces unexpectedly accessed before parsing when searching for %s", namespace)
 	}
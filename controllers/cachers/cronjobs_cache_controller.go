@@ -0,0 +1,53 @@
+package cachers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/chongyangshi/Order/logging"
+)
+
+// cronJobsCacheController holds an eventually consistent cache of cronjobs
+// to allow Order to determine what CronJobs need their future Jobs to pick up
+// changes to managed resources.
+type cronJobsCacheController struct {
+	factory informers.SharedInformerFactory
+	lister  batchlisters.CronJobLister
+	synced  cache.InformerSynced
+}
+
+// newCronJobsController initialises a CronJobs controller
+func newCronJobsController(clientSet kubernetes.Interface, resyncInterval time.Duration) *cronJobsCacheController {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncInterval)
+	informer := informerFactory.Batch().V1().CronJobs()
+
+	controller := &cronJobsCacheController{
+		factory: informerFactory,
+	}
+
+	controller.lister = informer.Lister()
+	controller.synced = informer.Informer().HasSynced
+
+	return controller
+}
+
+// Run initialises and starts the controller
+func (c *cronJobsCacheController) run(stopChan chan struct{}) {
+	defer runtime.HandleCrash()
+
+	logging.Log("Starting cronjob cache controller.")
+	defer logging.Log("Shutting down cronjob cache controller.")
+
+	c.factory.Start(stopChan)
+
+	if ok := cache.WaitForCacheSync(stopChan, c.synced); !ok {
+		logging.Fatal("Failed to wait for cache synchronization")
+	}
+
+	<-stopChan
+}
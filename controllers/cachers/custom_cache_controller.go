@@ -0,0 +1,59 @@
+package cachers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// customCacheController holds an eventually consistent cache of custom resources
+// of a kind configured as a custom pod controller, such as Argo Rollouts, to allow
+// Order to determine what custom resources need to be rolling restarted.
+type customCacheController struct {
+	kind     *proto.CustomPodController
+	resource schema.GroupVersionResource
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	lister   cache.GenericLister
+	synced   cache.InformerSynced
+}
+
+// newCustomController initialises a controller for custom resources of the kind,
+// served by the API as the resource
+func newCustomController(dynamicClient dynamic.Interface, kind *proto.CustomPodController, resource schema.GroupVersionResource, resyncInterval time.Duration) *customCacheController {
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, resyncInterval)
+	informer := informerFactory.ForResource(resource)
+
+	controller := &customCacheController{
+		kind:     kind,
+		resource: resource,
+		factory:  informerFactory,
+	}
+
+	controller.lister = informer.Lister()
+	controller.synced = informer.Informer().HasSynced
+
+	return controller
+}
+
+// Run initialises and starts the controller
+func (c *customCacheController) run(stopChan chan struct{}) {
+	defer runtime.HandleCrash()
+
+	logging.Log("Starting %s cache controller.", c.kind.Kind)
+	defer logging.Log("Shutting down %s cache controller.", c.kind.Kind)
+
+	c.factory.Start(stopChan)
+
+	if ok := cache.WaitForCacheSync(stopChan, c.synced); !ok {
+		logging.Fatal("Failed to wait for cache synchronization")
+	}
+
+	<-stopChan
+}
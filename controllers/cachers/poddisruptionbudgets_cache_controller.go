@@ -0,0 +1,53 @@
+package cachers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	policylisters "k8s.io/client-go/listers/policy/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/chongyangshi/Order/logging"
+)
+
+// podDisruptionBudgetsCacheController holds an eventually consistent cache of
+// PodDisruptionBudgets to allow Order to defer rolling restarts of pod controllers
+// whose pods can't currently afford disruptions.
+type podDisruptionBudgetsCacheController struct {
+	factory informers.SharedInformerFactory
+	lister  policylisters.PodDisruptionBudgetLister
+	synced  cache.InformerSynced
+}
+
+// newPodDisruptionBudgetsController initialises a PodDisruptionBudgets controller
+func newPodDisruptionBudgetsController(clientSet kubernetes.Interface, resyncInterval time.Duration) *podDisruptionBudgetsCacheController {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncInterval)
+	informer := informerFactory.Policy().V1().PodDisruptionBudgets()
+
+	controller := &podDisruptionBudgetsCacheController{
+		factory: informerFactory,
+	}
+
+	controller.lister = informer.Lister()
+	controller.synced = informer.Informer().HasSynced
+
+	return controller
+}
+
+// run initialises and starts the controller
+func (c *podDisruptionBudgetsCacheController) run(stopChan chan struct{}) {
+	defer runtime.HandleCrash()
+
+	logging.Log("Starting poddisruptionbudget cache controller.")
+	defer logging.Log("Shutting down poddisruptionbudget cache controller.")
+
+	c.factory.Start(stopChan)
+
+	if ok := cache.WaitForCacheSync(stopChan, c.synced); !ok {
+		logging.Fatal("Failed to wait for cache synchronization")
+	}
+
+	<-stopChan
+}
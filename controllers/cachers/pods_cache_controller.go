@@ -0,0 +1,53 @@
+package cachers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/chongyangshi/Order/logging"
+)
+
+// podsCacheController holds an eventually consistent cache of pods to allow Order
+// to determine what bare pods need to be restarted, and to restart pods of
+// controllers which don't roll their pods by themselves.
+type podsCacheController struct {
+	factory informers.SharedInformerFactory
+	lister  corelisters.PodLister
+	synced  cache.InformerSynced
+}
+
+// newPodsController initialises a Pods controller
+func newPodsController(clientSet kubernetes.Interface, resyncInterval time.Duration) *podsCacheController {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncInterval)
+	informer := informerFactory.Core().V1().Pods()
+
+	controller := &podsCacheController{
+		factory: informerFactory,
+	}
+
+	controller.lister = informer.Lister()
+	controller.synced = informer.Informer().HasSynced
+
+	return controller
+}
+
+// run initialises and starts the controller
+func (c *podsCacheController) run(stopChan chan struct{}) {
+	defer runtime.HandleCrash()
+
+	logging.Log("Starting pod cache controller.")
+	defer logging.Log("Shutting down pod cache controller.")
+
+	c.factory.Start(stopChan)
+
+	if ok := cache.WaitForCacheSync(stopChan, c.synced); !ok {
+		logging.Fatal("Failed to wait for cache synchronization")
+	}
+
+	<-stopChan
+}
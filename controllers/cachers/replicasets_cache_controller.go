@@ -0,0 +1,53 @@
+package cachers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/chongyangshi/Order/logging"
+)
+
+// replicaSetsCacheController holds an eventually consistent cache of replicasets
+// to allow Order to determine what standalone ReplicaSet pods need to be restarted
+// quickly.
+type replicaSetsCacheController struct {
+	factory informers.SharedInformerFactory
+	lister  appslisters.ReplicaSetLister
+	synced  cache.InformerSynced
+}
+
+// newReplicaSetsController initialises a ReplicaSets controller
+func newReplicaSetsController(clientSet kubernetes.Interface, resyncInterval time.Duration) *replicaSetsCacheController {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncInterval)
+	informer := informerFactory.Apps().V1().ReplicaSets()
+
+	controller := &replicaSetsCacheController{
+		factory: informerFactory,
+	}
+
+	controller.lister = informer.Lister()
+	controller.synced = informer.Informer().HasSynced
+
+	return controller
+}
+
+// run initialises and starts the controller
+func (c *replicaSetsCacheController) run(stopChan chan struct{}) {
+	defer runtime.HandleCrash()
+
+	logging.Log("Starting replicaset cache controller.")
+	defer logging.Log("Shutting down replicaset cache controller.")
+
+	c.factory.Start(stopChan)
+
+	if ok := cache.WaitForCacheSync(stopChan, c.synced); !ok {
+		logging.Fatal("Failed to wait for cache synchronization")
+	}
+
+	<-stopChan
+}
@@ -0,0 +1,53 @@
+package cachers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/chongyangshi/Order/logging"
+)
+
+// replicationControllersCacheController holds an eventually consistent cache of
+// replicationcontrollers to allow Order to determine what ReplicationController pods
+// need to be restarted quickly.
+type replicationControllersCacheController struct {
+	factory informers.SharedInformerFactory
+	lister  corelisters.ReplicationControllerLister
+	synced  cache.InformerSynced
+}
+
+// newReplicationControllersController initialises a ReplicationControllers controller
+func newReplicationControllersController(clientSet kubernetes.Interface, resyncInterval time.Duration) *replicationControllersCacheController {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncInterval)
+	informer := informerFactory.Core().V1().ReplicationControllers()
+
+	controller := &replicationControllersCacheController{
+		factory: informerFactory,
+	}
+
+	controller.lister = informer.Lister()
+	controller.synced = informer.Informer().HasSynced
+
+	return controller
+}
+
+// run initialises and starts the controller
+func (c *replicationControllersCacheController) run(stopChan chan struct{}) {
+	defer runtime.HandleCrash()
+
+	logging.Log("Starting replicationcontroller cache controller.")
+	defer logging.Log("Shutting down replicationcontroller cache controller.")
+
+	c.factory.Start(stopChan)
+
+	if ok := cache.WaitForCacheSync(stopChan, c.synced); !ok {
+		logging.Fatal("Failed to wait for cache synchronization")
+	}
+
+	<-stopChan
+}
@@ -3,11 +3,13 @@ package configmaps
 import (
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 
 	"github.com/chongyangshi/Order/logging"
 )
@@ -17,6 +19,10 @@ type ConfigMapsController struct {
 	factory informers.SharedInformerFactory
 	Lister  corelisters.ConfigMapLister
 	Synced  cache.InformerSynced
+
+	// Queue holds keys of ConfigMaps which have changed, for the processor to drain
+	// and re-evaluate pod controllers referencing them.
+	Queue workqueue.RateLimitingInterface
 }
 
 // NewConfigMapsController initialises a ConfigMaps controller
@@ -26,14 +32,33 @@ func NewConfigMapsController(clientSet kubernetes.Interface, resyncInterval time
 
 	controller := &ConfigMapsController{
 		factory: informerFactory,
+		Queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ConfigMaps"),
 	}
 
-	// We don't process informer events for the time being, and just rely on
-	// fixed interval resynchronizations.
+	// We only queue updates to existing ConfigMaps, as these are what require pod
+	// controllers to be restarted promptly. Additions and deletions, as well as
+	// anything missed here, are still covered by the processor's periodic control
+	// loop as a safety net.
 	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    func(obj interface{}) {},
-		UpdateFunc: func(old interface{}, new interface{}) {},
-		DeleteFunc: func(obj interface{}) {},
+		UpdateFunc: func(old interface{}, new interface{}) {
+			oldConfigMap, ok := old.(*corev1.ConfigMap)
+			if !ok {
+				return
+			}
+
+			newConfigMap, ok := new.(*corev1.ConfigMap)
+			if !ok {
+				return
+			}
+
+			// Periodic resyncs deliver updates without any change in resource version,
+			// which we don't need to process.
+			if oldConfigMap.ResourceVersion == newConfigMap.ResourceVersion {
+				return
+			}
+
+			controller.enqueue(newConfigMap)
+		},
 	})
 
 	controller.Lister = informer.Lister()
@@ -45,6 +70,7 @@ func NewConfigMapsController(clientSet kubernetes.Interface, resyncInterval time
 // Run initialises and starts the controller
 func (c *ConfigMapsController) Run(stopChan chan struct{}) {
 	defer runtime.HandleCrash()
+	defer c.Queue.ShutDown()
 
 	logging.Log("Starting configmap controller.")
 	defer logging.Log("Shutting down configmap controller.")
@@ -57,3 +83,15 @@ func (c *ConfigMapsController) Run(stopChan chan struct{}) {
 
 	<-stopChan
 }
+
+// enqueue adds the key of a changed ConfigMap to the work queue
+func (c *ConfigMapsController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	// Fresh changes are added without rate limiting, which only applies to retries
+	c.Queue.Add(key)
+}
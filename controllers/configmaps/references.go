@@ -4,6 +4,9 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/chongyangshi/Order/proto"
 )
 
 // DaemonSetHasReference returns a boolean value on whether it references the ConfigMap
@@ -54,6 +57,22 @@ func JobHasReference(job *batchv1.Job, cm *corev1.ConfigMap) bool {
 	return podSpecHasReference(job.Spec.Template.Spec, cm.Name)
 }
 
+// CronJobHasReference returns a boolean value on whether the Job template of the
+// CronJob references the ConfigMap we are looking for.
+func CronJobHasReference(cronJob *batchv1.CronJob, cm *corev1.ConfigMap) bool {
+	if cronJob == nil || cm == nil {
+		return false
+	}
+
+	// If CronJob and ConfigMap are in different namespaces, the former won't be
+	// able to reference the latter, so it won't be what we are looking for.
+	if cronJob.Namespace != cm.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(cronJob.Spec.JobTemplate.Spec.Template.Spec, cm.Name)
+}
+
 // StatefulSetHasReference returns a boolean value on whether it references the ConfigMap
 // we are looking for.
 func StatefulSetHasReference(sts *appsv1.StatefulSet, cm *corev1.ConfigMap) bool {
@@ -70,35 +89,174 @@ func StatefulSetHasReference(sts *appsv1.StatefulSet, cm *corev1.ConfigMap) bool
 	return podSpecHasReference(sts.Spec.Template.Spec, cm.Name)
 }
 
-func podSpecHasReference(podSpec corev1.PodSpec, name string) bool {
-	// Check whether our ConfigMap is mounted as a volume in the pod controller's pod template.
+// ReplicaSetHasReference returns a boolean value on whether it references the ConfigMap
+// we are looking for.
+func ReplicaSetHasReference(rs *appsv1.ReplicaSet, cm *corev1.ConfigMap) bool {
+	if rs == nil || cm == nil {
+		return false
+	}
+
+	// If ReplicaSet and ConfigMap are in different namespaces, the former won't be
+	// able to reference the latter, so it won't be what we are looking for.
+	if rs.Namespace != cm.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(rs.Spec.Template.Spec, cm.Name)
+}
+
+// ReplicationControllerHasReference returns a boolean value on whether it references
+// the ConfigMap we are looking for.
+func ReplicationControllerHasReference(rc *corev1.ReplicationController, cm *corev1.ConfigMap) bool {
+	if rc == nil || cm == nil || rc.Spec.Template == nil {
+		return false
+	}
+
+	// If ReplicationController and ConfigMap are in different namespaces, the former
+	// won't be able to reference the latter, so it won't be what we are looking for.
+	if rc.Namespace != cm.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(rc.Spec.Template.Spec, cm.Name)
+}
+
+// PodHasReference returns a boolean value on whether it references the ConfigMap
+// we are looking for.
+func PodHasReference(pod *corev1.Pod, cm *corev1.ConfigMap) bool {
+	if pod == nil || cm == nil {
+		return false
+	}
+
+	// If Pod and ConfigMap are in different namespaces, the former won't be
+	// able to reference the latter, so it won't be what we are looking for.
+	if pod.Namespace != cm.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(pod.Spec, cm.Name)
+}
+
+// PodTemplateHasReference returns a boolean value on whether a pod template embedded
+// in an object, such as a custom resource, references the ConfigMap we are looking for.
+func PodTemplateHasReference(obj metav1.Object, template *corev1.PodTemplateSpec, cm *corev1.ConfigMap) bool {
+	if obj == nil || template == nil || cm == nil {
+		return false
+	}
+
+	// If the object and ConfigMap are in different namespaces, the former won't be
+	// able to reference the latter, so it won't be what we are looking for.
+	if obj.GetNamespace() != cm.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(template.Spec, cm.Name)
+}
+
+// PodSpecReferences returns names of all ConfigMaps referenced by a pod spec, in the order
+// they are referenced. ConfigMaps can be mounted as volumes directly or through projected
+// volumes, or referenced in environment variables of any container, init container or
+// ephemeral container, either individually or through envFrom.
+func PodSpecReferences(podSpec corev1.PodSpec) []string {
+	var names []string
+	seen := map[string]bool{}
+	walkPodSpecReferences(podSpec, func(name string, keys []string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	})
+
+	return names
+}
+
+// PodSpecKeyReferences returns keys of the named ConfigMap consumed by a pod spec, or nil
+// if the pod spec does not reference it at all.
+func PodSpecKeyReferences(podSpec corev1.PodSpec, name string) *proto.KeyReferences {
+	var references *proto.KeyReferences
+	walkPodSpecReferences(podSpec, func(referenced string, keys []string) {
+		if referenced != name {
+			return
+		}
+
+		if references == nil {
+			references = &proto.KeyReferences{}
+		}
+
+		if len(keys) == 0 {
+			references.All = true
+			return
+		}
+
+		for _, key := range keys {
+			references.AddKey(key)
+		}
+	})
+
+	return references
+}
+
+// walkPodSpecReferences calls visit on each reference to a ConfigMap in a pod spec, with
+// keys consumed by the reference, or no keys if the ConfigMap is consumed as a whole.
+func walkPodSpecReferences(podSpec corev1.PodSpec, visit func(name string, keys []string)) {
+	// ConfigMaps mounted as volumes in the pod template.
 	for _, volume := range podSpec.Volumes {
-		if volume.ConfigMap == nil {
+		if volume.ConfigMap != nil && volume.ConfigMap.Name != "" {
+			visit(volume.ConfigMap.Name, itemKeys(volume.ConfigMap.Items))
+		}
+
+		if volume.Projected == nil {
 			continue
 		}
 
-		if volume.ConfigMap.Name == name {
-			return true
+		for _, source := range volume.Projected.Sources {
+			if source.ConfigMap != nil && source.ConfigMap.Name != "" {
+				visit(source.ConfigMap.Name, itemKeys(source.ConfigMap.Items))
+			}
 		}
 	}
 
-	// Check whether our ConfigMap is referenced in the pod controller's container environment
-	// variables.
-	for _, container := range podSpec.Containers {
+	// ConfigMaps referenced in the pod template's container environment variables.
+	for _, container := range podSpecContainers(podSpec) {
 		for _, env := range container.Env {
-			if env.ValueFrom == nil {
-				continue
-			}
-
-			if env.ValueFrom.ConfigMapKeyRef == nil {
-				continue
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name != "" {
+				visit(env.ValueFrom.ConfigMapKeyRef.Name, []string{env.ValueFrom.ConfigMapKeyRef.Key})
 			}
+		}
 
-			if env.ValueFrom.ConfigMapKeyRef.Name == name {
-				return true
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name != "" {
+				visit(envFrom.ConfigMapRef.Name, nil)
 			}
 		}
 	}
+}
 
-	return false
+// itemKeys returns keys selected by items of a volume source, which if empty means
+// all keys are mounted.
+func itemKeys(items []corev1.KeyToPath) []string {
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+
+	return keys
+}
+
+// podSpecContainers returns all containers in a pod spec, including init containers
+// and ephemeral containers.
+func podSpecContainers(podSpec corev1.PodSpec) []corev1.Container {
+	var containers []corev1.Container
+	containers = append(containers, podSpec.InitContainers...)
+	containers = append(containers, podSpec.Containers...)
+	for _, ephemeral := range podSpec.EphemeralContainers {
+		containers = append(containers, corev1.Container(ephemeral.EphemeralContainerCommon))
+	}
+
+	return containers
+}
+
+func podSpecHasReference(podSpec corev1.PodSpec, name string) bool {
+	return PodSpecKeyReferences(podSpec, name) != nil
 }
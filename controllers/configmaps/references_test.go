@@ -0,0 +1,179 @@
+package configmaps
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func configMapVolume(name string, keys ...string) corev1.Volume {
+	var items []corev1.KeyToPath
+	for _, key := range keys {
+		items = append(items, corev1.KeyToPath{Key: key, Path: key})
+	}
+
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Items: items},
+		},
+	}
+}
+
+func projectedVolume(name string, keys ...string) corev1.Volume {
+	var items []corev1.KeyToPath
+	for _, key := range keys {
+		items = append(items, corev1.KeyToPath{Key: key, Path: key})
+	}
+
+	return corev1.Volume{
+		Name: "projected",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					ConfigMap: &corev1.ConfigMapProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Items:                items,
+					},
+				}},
+			},
+		},
+	}
+}
+
+func keyRefEnv(name, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: key,
+		ValueFrom: &corev1.EnvVarSource{
+			ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+func envFrom(name string) corev1.EnvFromSource {
+	return corev1.EnvFromSource{
+		ConfigMapRef: &corev1.ConfigMapEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		},
+	}
+}
+
+func TestPodSpecKeyReferences(t *testing.T) {
+	tests := []struct {
+		name       string
+		podSpec    corev1.PodSpec
+		expectNil  bool
+		expectAll  bool
+		expectKeys []string
+	}{
+		{
+			name:      "not referenced",
+			podSpec:   corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("other")}},
+			expectNil: true,
+		},
+		{
+			name: "only a Secret of the same name referenced",
+			podSpec: corev1.PodSpec{
+				Volumes: []corev1.Volume{{
+					Name:         "app",
+					VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "app"}},
+				}},
+			},
+			expectNil: true,
+		},
+		{
+			name:      "volume without items",
+			podSpec:   corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("app")}},
+			expectAll: true,
+		},
+		{
+			name:       "volume with items",
+			podSpec:    corev1.PodSpec{Volumes: []corev1.Volume{configMapVolume("app", "app.yaml", "logging.yaml")}},
+			expectKeys: []string{"app.yaml", "logging.yaml"},
+		},
+		{
+			name:       "projected volume with items",
+			podSpec:    corev1.PodSpec{Volumes: []corev1.Volume{projectedVolume("app", "ca.crt")}},
+			expectKeys: []string{"ca.crt"},
+		},
+		{
+			name:      "projected volume without items",
+			podSpec:   corev1.PodSpec{Volumes: []corev1.Volume{projectedVolume("app")}},
+			expectAll: true,
+		},
+		{
+			name: "key references across containers",
+			podSpec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Env: []corev1.EnvVar{keyRefEnv("app", "log-level")}}},
+				Containers:     []corev1.Container{{Env: []corev1.EnvVar{keyRefEnv("app", "feature-flags"), keyRefEnv("other", "ca.crt")}}},
+			},
+			expectKeys: []string{"feature-flags", "log-level"},
+		},
+		{
+			name: "ephemeral container key reference",
+			podSpec: corev1.PodSpec{
+				EphemeralContainers: []corev1.EphemeralContainer{{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{Env: []corev1.EnvVar{keyRefEnv("app", "debug-port")}},
+				}},
+			},
+			expectKeys: []string{"debug-port"},
+		},
+		{
+			name: "envFrom consumes all keys",
+			podSpec: corev1.PodSpec{
+				Containers: []corev1.Container{{EnvFrom: []corev1.EnvFromSource{envFrom("app")}}},
+			},
+			expectAll: true,
+		},
+		{
+			name: "whole reference alongside key references",
+			podSpec: corev1.PodSpec{
+				Volumes:    []corev1.Volume{configMapVolume("app")},
+				Containers: []corev1.Container{{Env: []corev1.EnvVar{keyRefEnv("app", "feature-flags")}}},
+			},
+			expectAll:  true,
+			expectKeys: []string{"feature-flags"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			references := PodSpecKeyReferences(test.podSpec, "app")
+			if test.expectNil {
+				if references != nil {
+					t.Errorf("Expected no references, got %+v", references)
+				}
+				return
+			}
+
+			if references == nil {
+				t.Fatalf("Expected references, got none")
+			}
+			if references.All != test.expectAll {
+				t.Errorf("Expected all keys referenced: %v, got %v", test.expectAll, references.All)
+			}
+			if keys := references.SortedKeys(); !reflect.DeepEqual(keys, test.expectKeys) {
+				t.Errorf("Expected keys %v, got %v", test.expectKeys, keys)
+			}
+		})
+	}
+}
+
+func TestPodSpecReferences(t *testing.T) {
+	podSpec := corev1.PodSpec{
+		Volumes: []corev1.Volume{configMapVolume("nginx"), projectedVolume("ca-bundle")},
+		Containers: []corev1.Container{{
+			Env:     []corev1.EnvVar{keyRefEnv("flags", "feature-flags"), keyRefEnv("nginx", "nginx.conf")},
+			EnvFrom: []corev1.EnvFromSource{envFrom("app")},
+		}},
+	}
+
+	expected := []string{"nginx", "ca-bundle", "flags", "app"}
+	if names := PodSpecReferences(podSpec); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected references %v, got %v", expected, names)
+	}
+}
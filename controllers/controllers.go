@@ -5,18 +5,27 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/util/workqueue"
 
+	"github.com/chongyangshi/Order/config"
 	"github.com/chongyangshi/Order/controllers/cachers"
 	"github.com/chongyangshi/Order/controllers/configmaps"
+	"github.com/chongyangshi/Order/controllers/customresources"
 	"github.com/chongyangshi/Order/controllers/secrets"
 	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
 )
 
 var (
-	configMapsController *configmaps.ConfigMapsController
-	secretsController    *secrets.SecretsController
+	configMapsController      *configmaps.ConfigMapsController
+	secretsController         *secrets.SecretsController
+	customResourceControllers map[schema.GroupVersionKind]*customresources.CustomResourcesController
 )
 
 // Init launches a processor which is responsible for periodically inspecting managed
@@ -24,17 +33,42 @@ var (
 // up-to-date in terms of restarts. If any isn't and they can be restarted based on the
 // cooldown configured, then the procesor will apply an annotation to ask Kubernetes to
 // restart the said pod controller.
-func Init(clientSet kubernetes.Interface, stopChan chan struct{}, resyncInterval time.Duration) {
+func Init(clientSet kubernetes.Interface, dynamicClient dynamic.Interface, stopChan chan struct{}, resyncInterval time.Duration) {
 	// Start cachers first to build a list of pod controllers
-	cachers.Init(clientSet, stopChan, resyncInterval)
+	cachers.Init(clientSet, dynamicClient, stopChan, resyncInterval)
 	logging.Log("Started all cache controllers")
 
 	// Now start controllers for managed resources.
 	secretsController = secrets.NewSecretsController(clientSet, resyncInterval)
 	go secretsController.Run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleManagedResource, "Secrets", secretsController.Synced)
 
 	configMapsController = configmaps.NewConfigMapsController(clientSet, resyncInterval)
 	go configMapsController.Run(stopChan)
+	metrics.RegisterInformer(metrics.InformerRoleManagedResource, "ConfigMaps", configMapsController.Synced)
+
+	// Custom managed resources are only known by kind in config, so we look up the API
+	// resources serving them before watching them.
+	customResourceControllers = map[schema.GroupVersionKind]*customresources.CustomResourcesController{}
+	if kinds := config.Config.CustomManagedResourceKinds(); len(kinds) > 0 {
+		groupResources, err := restmapper.GetAPIGroupResources(clientSet.Discovery())
+		if err != nil {
+			logging.Fatal("Error discovering API resources for custom managed resources: %v", err)
+		}
+		mapper := restmapper.NewDiscoveryRESTMapper(groupResources)
+
+		for _, gvk := range kinds {
+			mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				logging.Fatal("Error finding API resource for custom managed resource %s: %v", gvk.String(), err)
+			}
+
+			controller := customresources.NewCustomResourcesController(dynamicClient, gvk, mapping.Resource, resyncInterval)
+			go controller.Run(stopChan)
+			metrics.RegisterInformer(metrics.InformerRoleManagedResource, gvk.Kind, controller.Synced)
+			customResourceControllers[gvk] = controller
+		}
+	}
 
 	logging.Log("Started all managed resource controllers, waiting for them to sync")
 
@@ -50,6 +84,13 @@ func Init(clientSet kubernetes.Interface, stopChan chan struct{}, resyncInterval
 			allSynced = false
 		}
 
+		for _, controller := range customResourceControllers {
+			if allSynced && !controller.Synced() {
+				logging.Debug("%s controller not yet synced", controller.Kind.Kind)
+				allSynced = false
+			}
+		}
+
 		if allSynced {
 			logging.Log("All managed resources controllers synced and ready")
 			break
@@ -62,16 +103,105 @@ func Init(clientSet kubernetes.Interface, stopChan chan struct{}, resyncInterval
 
 // GetSecrets returns all Secrets currently in controller cache, whether managed or not
 func GetSecrets() ([]*corev1.Secret, error) {
+	return GetSecretsBySelector(labels.Everything())
+}
+
+// GetSecretsBySelector returns Secrets currently in controller cache matching a label
+// selector, whether managed or not
+func GetSecretsBySelector(selector labels.Selector) ([]*corev1.Secret, error) {
 	if secretsController == nil {
 		return nil, fmt.Errorf("Secret controller is not yet initialised")
 	}
-	return secretsController.Lister.List(labels.Everything())
+	return secretsController.Lister.List(selector)
+}
+
+// GetSecret returns a Secret currently in controller cache by its namespace and name
+func GetSecret(namespace, name string) (*corev1.Secret, error) {
+	if secretsController == nil {
+		return nil, fmt.Errorf("Secret controller is not yet initialised")
+	}
+	return secretsController.Lister.Secrets(namespace).Get(name)
 }
 
 // GetConfigMaps returns all ConfigMaps currently in controller cache
 func GetConfigMaps() ([]*corev1.ConfigMap, error) {
+	return GetConfigMapsBySelector(labels.Everything())
+}
+
+// GetConfigMapsBySelector returns ConfigMaps currently in controller cache matching a
+// label selector
+func GetConfigMapsBySelector(selector labels.Selector) ([]*corev1.ConfigMap, error) {
+	if configMapsController == nil {
+		return nil, fmt.Errorf("ConfigMap controller is not yet initialised")
+	}
+	return configMapsController.Lister.List(selector)
+}
+
+// GetConfigMap returns a ConfigMap currently in controller cache by its namespace and name
+func GetConfigMap(namespace, name string) (*corev1.ConfigMap, error) {
 	if configMapsController == nil {
 		return nil, fmt.Errorf("ConfigMap controller is not yet initialised")
 	}
-	return configMapsController.Lister.List(labels.Everything())
+	return configMapsController.Lister.ConfigMaps(namespace).Get(name)
+}
+
+// GetSecretsQueue returns the work queue holding keys of Secrets which have changed
+func GetSecretsQueue() (workqueue.RateLimitingInterface, error) {
+	if secretsController == nil {
+		return nil, fmt.Errorf("Secret controller is not yet initialised")
+	}
+	return secretsController.Queue, nil
+}
+
+// GetConfigMapsQueue returns the work queue holding keys of ConfigMaps which have changed
+func GetConfigMapsQueue() (workqueue.RateLimitingInterface, error) {
+	if configMapsController == nil {
+		return nil, fmt.Errorf("ConfigMap controller is not yet initialised")
+	}
+	return configMapsController.Queue, nil
+}
+
+// GetCustomResourcesBySelector returns custom resources of a kind managed by Order
+// currently in controller cache matching a label selector
+func GetCustomResourcesBySelector(kind schema.GroupVersionKind, selector labels.Selector) ([]*unstructured.Unstructured, error) {
+	controller, found := customResourceControllers[kind]
+	if !found {
+		return nil, fmt.Errorf("%s controller is not yet initialised", kind.Kind)
+	}
+
+	objects, err := controller.Lister.List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*unstructured.Unstructured
+	for _, object := range objects {
+		if u, ok := object.(*unstructured.Unstructured); ok && u != nil {
+			results = append(results, u)
+		}
+	}
+
+	return results, nil
+}
+
+// GetCustomResourceType returns the API resource serving custom resources of a kind
+// managed by Order
+func GetCustomResourceType(kind schema.GroupVersionKind) (schema.GroupVersionResource, error) {
+	controller, found := customResourceControllers[kind]
+	if !found {
+		return schema.GroupVersionResource{}, fmt.Errorf("%s controller is not yet initialised", kind.Kind)
+	}
+
+	return controller.Resource, nil
+}
+
+// GetCustomResourcesQueues returns work queues holding keys of custom resources which
+// have changed, by their kinds
+func GetCustomResourcesQueues() map[string]workqueue.RateLimitingInterface {
+	queues := map[string]workqueue.RateLimitingInterface{}
+	for gvk, controller := range customResourceControllers {
+		queues[gvk.Kind] = controller.Queue
+	}
+
+	return queues
 }
@@ -0,0 +1,102 @@
+package customresources
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/chongyangshi/Order/logging"
+)
+
+// CustomResourcesController is a controller monitoring changes to custom resources of
+// a kind managed by Order, such as cert-manager Certificates
+type CustomResourcesController struct {
+	Kind     schema.GroupVersionKind
+	Resource schema.GroupVersionResource
+
+	factory dynamicinformer.DynamicSharedInformerFactory
+	Lister  cache.GenericLister
+	Synced  cache.InformerSynced
+
+	// Queue holds keys of custom resources which have changed, for the processor to
+	// drain and re-evaluate pod controllers linked to them.
+	Queue workqueue.RateLimitingInterface
+}
+
+// NewCustomResourcesController initialises a controller for custom resources of the kind,
+// served by the API as the resource
+func NewCustomResourcesController(dynamicClient dynamic.Interface, kind schema.GroupVersionKind, resource schema.GroupVersionResource, resyncInterval time.Duration) *CustomResourcesController {
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, resyncInterval)
+	informer := informerFactory.ForResource(resource)
+
+	controller := &CustomResourcesController{
+		Kind:     kind,
+		Resource: resource,
+		factory:  informerFactory,
+		Queue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), kind.Kind),
+	}
+
+	// As with Secrets and ConfigMaps, we only queue updates to existing custom
+	// resources, leaving the rest to the processor's periodic control loop.
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old interface{}, new interface{}) {
+			oldResource, ok := old.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+
+			newResource, ok := new.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+
+			// Periodic resyncs deliver updates without any change in resource version,
+			// which we don't need to process.
+			if oldResource.GetResourceVersion() == newResource.GetResourceVersion() {
+				return
+			}
+
+			controller.enqueue(newResource)
+		},
+	})
+
+	controller.Lister = informer.Lister()
+	controller.Synced = informer.Informer().HasSynced
+
+	return controller
+}
+
+// Run initialises and starts the controller
+func (c *CustomResourcesController) Run(stopChan chan struct{}) {
+	defer runtime.HandleCrash()
+	defer c.Queue.ShutDown()
+
+	logging.Log("Starting %s controller.", c.Kind.Kind)
+	defer logging.Log("Shutting down %s controller.", c.Kind.Kind)
+
+	c.factory.Start(stopChan)
+
+	if ok := cache.WaitForCacheSync(stopChan, c.Synced); !ok {
+		logging.Fatal("Failed to wait for cache synchronization")
+	}
+
+	<-stopChan
+}
+
+// enqueue adds the key of a changed custom resource to the work queue
+func (c *CustomResourcesController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	// Fresh changes are added without rate limiting, which only applies to retries
+	c.Queue.Add(key)
+}
@@ -4,6 +4,9 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/chongyangshi/Order/proto"
 )
 
 // DaemonSetHasReference returns a boolean value on whether it references the Secret
@@ -54,6 +57,22 @@ func JobHasReference(job *batchv1.Job, secret *corev1.Secret) bool {
 	return podSpecHasReference(job.Spec.Template.Spec, secret.Name)
 }
 
+// CronJobHasReference returns a boolean value on whether the Job template of the
+// CronJob references the Secret we are looking for.
+func CronJobHasReference(cronJob *batchv1.CronJob, secret *corev1.Secret) bool {
+	if cronJob == nil || secret == nil {
+		return false
+	}
+
+	// If CronJob and Secret are in different namespaces, the former won't be
+	// able to reference the latter, so it won't be what we are looking for.
+	if cronJob.Namespace != secret.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(cronJob.Spec.JobTemplate.Spec.Template.Spec, secret.Name)
+}
+
 // StatefulSetHasReference returns a boolean value on whether it references the Secret
 // we are looking for.
 func StatefulSetHasReference(sts *appsv1.StatefulSet, secret *corev1.Secret) bool {
@@ -70,35 +89,176 @@ func StatefulSetHasReference(sts *appsv1.StatefulSet, secret *corev1.Secret) boo
 	return podSpecHasReference(sts.Spec.Template.Spec, secret.Name)
 }
 
-func podSpecHasReference(podSpec corev1.PodSpec, name string) bool {
-	// Check whether our Secret is mounted as a volume in the pod controller's pod template.
+// ReplicaSetHasReference returns a boolean value on whether it references the Secret
+// we are looking for.
+func ReplicaSetHasReference(rs *appsv1.ReplicaSet, secret *corev1.Secret) bool {
+	if rs == nil || secret == nil {
+		return false
+	}
+
+	// If ReplicaSet and Secret are in different namespaces, the former won't be
+	// able to reference the latter, so it won't be what we are looking for.
+	if rs.Namespace != secret.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(rs.Spec.Template.Spec, secret.Name)
+}
+
+// ReplicationControllerHasReference returns a boolean value on whether it references
+// the Secret we are looking for.
+func ReplicationControllerHasReference(rc *corev1.ReplicationController, secret *corev1.Secret) bool {
+	if rc == nil || secret == nil || rc.Spec.Template == nil {
+		return false
+	}
+
+	// If ReplicationController and Secret are in different namespaces, the former
+	// won't be able to reference the latter, so it won't be what we are looking for.
+	if rc.Namespace != secret.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(rc.Spec.Template.Spec, secret.Name)
+}
+
+// PodHasReference returns a boolean value on whether it references the Secret
+// we are looking for.
+func PodHasReference(pod *corev1.Pod, secret *corev1.Secret) bool {
+	if pod == nil || secret == nil {
+		return false
+	}
+
+	// If Pod and Secret are in different namespaces, the former won't be
+	// able to reference the latter, so it won't be what we are looking for.
+	if pod.Namespace != secret.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(pod.Spec, secret.Name)
+}
+
+// PodTemplateHasReference returns a boolean value on whether a pod template embedded
+// in an object, such as a custom resource, references the Secret we are looking for.
+func PodTemplateHasReference(obj metav1.Object, template *corev1.PodTemplateSpec, secret *corev1.Secret) bool {
+	if obj == nil || template == nil || secret == nil {
+		return false
+	}
+
+	// If the object and Secret are in different namespaces, the former won't be
+	// able to reference the latter, so it won't be what we are looking for.
+	if obj.GetNamespace() != secret.Namespace {
+		return false
+	}
+
+	return podSpecHasReference(template.Spec, secret.Name)
+}
+
+// PodSpecReferences returns names of all Secrets referenced by a pod spec, in the order
+// they are referenced. Secrets can be mounted as volumes directly or through projected
+// volumes, or referenced in environment variables of any container, init container or
+// ephemeral container, either individually or through envFrom.
+// Credentials used by Kubernetes itself rather than the workload, such as image pull
+// Secrets and Secrets of volume plugins, are not included.
+func PodSpecReferences(podSpec corev1.PodSpec) []string {
+	var names []string
+	seen := map[string]bool{}
+	walkPodSpecReferences(podSpec, func(name string, keys []string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	})
+
+	return names
+}
+
+// PodSpecKeyReferences returns keys of the named Secret consumed by a pod spec, or nil
+// if the pod spec does not reference it at all.
+func PodSpecKeyReferences(podSpec corev1.PodSpec, name string) *proto.KeyReferences {
+	var references *proto.KeyReferences
+	walkPodSpecReferences(podSpec, func(referenced string, keys []string) {
+		if referenced != name {
+			return
+		}
+
+		if references == nil {
+			references = &proto.KeyReferences{}
+		}
+
+		if len(keys) == 0 {
+			references.All = true
+			return
+		}
+
+		for _, key := range keys {
+			references.AddKey(key)
+		}
+	})
+
+	return references
+}
+
+// walkPodSpecReferences calls visit on each reference to a Secret in a pod spec, with
+// keys consumed by the reference, or no keys if the Secret is consumed as a whole.
+func walkPodSpecReferences(podSpec corev1.PodSpec, visit func(name string, keys []string)) {
+	// Secrets mounted as volumes in the pod template.
 	for _, volume := range podSpec.Volumes {
-		if volume.Secret == nil {
+		if volume.Secret != nil && volume.Secret.SecretName != "" {
+			visit(volume.Secret.SecretName, itemKeys(volume.Secret.Items))
+		}
+
+		if volume.Projected == nil {
 			continue
 		}
 
-		if volume.Secret.SecretName == name {
-			return true
+		for _, source := range volume.Projected.Sources {
+			if source.Secret != nil && source.Secret.Name != "" {
+				visit(source.Secret.Name, itemKeys(source.Secret.Items))
+			}
 		}
 	}
 
-	// Check whether our Secret is referenced in the pod controller's container environment
-	// variables.
-	for _, container := range podSpec.Containers {
+	// Secrets referenced in the pod template's container environment variables.
+	for _, container := range podSpecContainers(podSpec) {
 		for _, env := range container.Env {
-			if env.ValueFrom == nil {
-				continue
-			}
-
-			if env.ValueFrom.SecretKeyRef == nil {
-				continue
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name != "" {
+				visit(env.ValueFrom.SecretKeyRef.Name, []string{env.ValueFrom.SecretKeyRef.Key})
 			}
+		}
 
-			if env.ValueFrom.SecretKeyRef.Name == name {
-				return true
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name != "" {
+				visit(envFrom.SecretRef.Name, nil)
 			}
 		}
 	}
+}
 
-	return false
+// itemKeys returns keys selected by items of a volume source, which if empty means
+// all keys are mounted.
+func itemKeys(items []corev1.KeyToPath) []string {
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+
+	return keys
+}
+
+// podSpecContainers returns all containers in a pod spec, including init containers
+// and ephemeral containers.
+func podSpecContainers(podSpec corev1.PodSpec) []corev1.Container {
+	var containers []corev1.Container
+	containers = append(containers, podSpec.InitContainers...)
+	containers = append(containers, podSpec.Containers...)
+	for _, ephemeral := range podSpec.EphemeralContainers {
+		containers = append(containers, corev1.Container(ephemeral.EphemeralContainerCommon))
+	}
+
+	return containers
+}
+
+func podSpecHasReference(podSpec corev1.PodSpec, name string) bool {
+	return PodSpecKeyReferences(podSpec, name) != nil
 }
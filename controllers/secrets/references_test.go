@@ -0,0 +1,177 @@
+package secrets
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func secretVolume(name string, keys ...string) corev1.Volume {
+	var items []corev1.KeyToPath
+	for _, key := range keys {
+		items = append(items, corev1.KeyToPath{Key: key, Path: key})
+	}
+
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: name, Items: items},
+		},
+	}
+}
+
+func projectedVolume(name string, keys ...string) corev1.Volume {
+	var items []corev1.KeyToPath
+	for _, key := range keys {
+		items = append(items, corev1.KeyToPath{Key: key, Path: key})
+	}
+
+	return corev1.Volume{
+		Name: "projected",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Items:                items,
+					},
+				}},
+			},
+		},
+	}
+}
+
+func keyRefEnv(name, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: key,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+func envFrom(name string) corev1.EnvFromSource {
+	return corev1.EnvFromSource{
+		SecretRef: &corev1.SecretEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		},
+	}
+}
+
+func TestPodSpecKeyReferences(t *testing.T) {
+	tests := []struct {
+		name       string
+		podSpec    corev1.PodSpec
+		expectNil  bool
+		expectAll  bool
+		expectKeys []string
+	}{
+		{
+			name:      "not referenced",
+			podSpec:   corev1.PodSpec{Volumes: []corev1.Volume{secretVolume("other")}},
+			expectNil: true,
+		},
+		{
+			name: "only referenced by image pull secrets",
+			podSpec: corev1.PodSpec{
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "creds"}},
+			},
+			expectNil: true,
+		},
+		{
+			name:      "volume without items",
+			podSpec:   corev1.PodSpec{Volumes: []corev1.Volume{secretVolume("creds")}},
+			expectAll: true,
+		},
+		{
+			name:       "volume with items",
+			podSpec:    corev1.PodSpec{Volumes: []corev1.Volume{secretVolume("creds", "tls.crt", "tls.key")}},
+			expectKeys: []string{"tls.crt", "tls.key"},
+		},
+		{
+			name:       "projected volume with items",
+			podSpec:    corev1.PodSpec{Volumes: []corev1.Volume{projectedVolume("creds", "token")}},
+			expectKeys: []string{"token"},
+		},
+		{
+			name:      "projected volume without items",
+			podSpec:   corev1.PodSpec{Volumes: []corev1.Volume{projectedVolume("creds")}},
+			expectAll: true,
+		},
+		{
+			name: "key references across containers",
+			podSpec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Env: []corev1.EnvVar{keyRefEnv("creds", "username")}}},
+				Containers:     []corev1.Container{{Env: []corev1.EnvVar{keyRefEnv("creds", "password"), keyRefEnv("other", "token")}}},
+			},
+			expectKeys: []string{"password", "username"},
+		},
+		{
+			name: "ephemeral container key reference",
+			podSpec: corev1.PodSpec{
+				EphemeralContainers: []corev1.EphemeralContainer{{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{Env: []corev1.EnvVar{keyRefEnv("creds", "debug")}},
+				}},
+			},
+			expectKeys: []string{"debug"},
+		},
+		{
+			name: "envFrom consumes all keys",
+			podSpec: corev1.PodSpec{
+				Containers: []corev1.Container{{EnvFrom: []corev1.EnvFromSource{envFrom("creds")}}},
+			},
+			expectAll: true,
+		},
+		{
+			name: "whole reference alongside key references",
+			podSpec: corev1.PodSpec{
+				Volumes:    []corev1.Volume{secretVolume("creds")},
+				Containers: []corev1.Container{{Env: []corev1.EnvVar{keyRefEnv("creds", "password")}}},
+			},
+			expectAll:  true,
+			expectKeys: []string{"password"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			references := PodSpecKeyReferences(test.podSpec, "creds")
+			if test.expectNil {
+				if references != nil {
+					t.Errorf("Expected no references, got %+v", references)
+				}
+				return
+			}
+
+			if references == nil {
+				t.Fatalf("Expected references, got none")
+			}
+			if references.All != test.expectAll {
+				t.Errorf("Expected all keys referenced: %v, got %v", test.expectAll, references.All)
+			}
+			if keys := references.SortedKeys(); !reflect.DeepEqual(keys, test.expectKeys) {
+				t.Errorf("Expected keys %v, got %v", test.expectKeys, keys)
+			}
+		})
+	}
+}
+
+func TestPodSpecReferences(t *testing.T) {
+	podSpec := corev1.PodSpec{
+		Volumes: []corev1.Volume{secretVolume("tls"), projectedVolume("token")},
+		Containers: []corev1.Container{{
+			Env:     []corev1.EnvVar{keyRefEnv("db", "password"), keyRefEnv("tls", "tls.crt")},
+			EnvFrom: []corev1.EnvFromSource{envFrom("app")},
+		}},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+	}
+
+	expected := []string{"tls", "token", "db", "app"}
+	if names := PodSpecReferences(podSpec); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected references %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,155 @@
+package election
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
+)
+
+const (
+	leaseName        = "order-leader"
+	defaultNamespace = "kube-system"
+
+	leaseDuration = time.Second * 15
+	renewDeadline = time.Second * 10
+	retryPeriod   = time.Second * 2
+)
+
+// Run campaigns for leadership through a Lease until the context is cancelled, calling
+// lead whenever this replica becomes the leader. The context passed to lead is cancelled
+// when leadership is lost, after which this replica campaigns again once lead has
+// returned. When the context is cancelled, leadership is released as soon as lead has
+// returned, so that another replica can take over promptly.
+func Run(ctx context.Context, clientSet kubernetes.Interface, lead func(ctx context.Context)) {
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			logging.Fatal("Error determining identity for leader election: %v", err)
+		}
+		identity = hostname
+	}
+
+	namespace := config.Config.LeaderElectionNamespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: namespace,
+		},
+		Client: clientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	logging.Log("Campaigning for leadership through Lease %s/%s as %s", namespace, leaseName, identity)
+	for ctx.Err() == nil {
+		campaign(ctx, lock, identity, lead)
+	}
+}
+
+// campaign runs a single leader election until leadership is lost or the context is
+// cancelled. It returns only after lead has returned, and releases leadership only after
+// that, so that the processor never runs without holding the Lease, and never overlaps
+// with itself.
+func campaign(ctx context.Context, lock resourcelock.Interface, identity string, lead func(ctx context.Context)) {
+	// Leader election runs under its own context, which is cancelled to release
+	// leadership only once lead has returned.
+	electionCtx, cancelElection := context.WithCancel(context.Background())
+	defer cancelElection()
+
+	// client-go calls OnStartedLeading in a goroutine it doesn't wait for, which may
+	// even start after the election has ended.
+	var (
+		leadingLock sync.Mutex
+		leading     bool
+		ended       bool
+		wg          sync.WaitGroup
+	)
+	waitForLead := func() {
+		leadingLock.Lock()
+		ended = true
+		leadingLock.Unlock()
+		wg.Wait()
+	}
+
+	electionDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			waitForLead()
+			cancelElection()
+		case <-electionDone:
+		}
+	}()
+
+	leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				leadingLock.Lock()
+				if ended {
+					leadingLock.Unlock()
+					return
+				}
+				leading = true
+				wg.Add(1)
+				leadingLock.Unlock()
+				defer wg.Done()
+
+				// Stop leading as soon as we are shutting down, before leadership is
+				// released.
+				leadCtx, cancelLead := context.WithCancel(leaderCtx)
+				defer cancelLead()
+				stop := context.AfterFunc(ctx, cancelLead)
+				defer stop()
+
+				logging.Log("Acquired leadership as %s, starting processor", identity)
+				metrics.SetLeader(true)
+				lead(leadCtx)
+			},
+			OnStoppedLeading: func() {
+				// OnStoppedLeading is called whenever campaigning stops, even if we never led.
+				leadingLock.Lock()
+				defer leadingLock.Unlock()
+				if !leading {
+					return
+				}
+				logging.Log("Lost leadership as %s, stopping processor", identity)
+				metrics.SetLeader(false)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					logging.Log("Current leader is %s, keeping caches warm as a follower", leader)
+				}
+			},
+		},
+	})
+	close(electionDone)
+
+	// Leadership may have been lost without the context being cancelled, in which case
+	// lead must still return before we campaign again.
+	waitForLead()
+}
@@ -17,7 +17,7 @@ func Log(format string, v ...interface{}) {
 		format = fmt.Sprintf("%s\n", format)
 	}
 
-	l.Printf(format, v)
+	l.Printf(format, v...)
 }
 
 // Fatal prints an error output to stderr and panics the program
@@ -26,13 +26,14 @@ func Fatal(format string, v ...interface{}) {
 		format = fmt.Sprintf("%s\n", format)
 	}
 
-	l.Fatalf(format, v)
+	l.Fatalf(format, v...)
 }
 
 // Debug prints a debug output to stdout if it is enabled in config
 func Debug(format string, v ...interface{}) {
 	if config.Config == nil {
 		log.Println("Error: Config not loaded when Debug is called")
+		return
 	}
 
 	if !config.Config.DebugOutput {
@@ -43,5 +44,5 @@ func Debug(format string, v ...interface{}) {
 		format = fmt.Sprintf("%s\n", format)
 	}
 
-	l.Printf(format, v)
+	l.Printf(format, v...)
 }
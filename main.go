@@ -1,23 +1,29 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
 	"time"
+	// Embed time zones for maintenance windows and blackouts, as container images
+	// may not ship them
+	_ "time/tzdata"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/chongyangshi/Order/config"
 	"github.com/chongyangshi/Order/controllers"
+	"github.com/chongyangshi/Order/election"
 	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
+	"github.com/chongyangshi/Order/processor"
 )
 
-type stop struct{}
-
 const (
 	ingressMultihomeNamespace = "multihome-ingress-system"
 	defaultConfigMountPath    = "/etc/order/config.yaml"
@@ -38,41 +44,68 @@ func main() {
 	}
 
 	// Now load Kubernetes config
-	var config *rest.Config
+	var kubeConfig *rest.Config
 
 	if os.Getenv("KUBECONFIG") != "" {
-		config, err = clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
+		kubeConfig, err = clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
 		if err != nil {
 			logging.Fatal("Cannot read kubeconfig from environment variable: %v", err.Error())
 		}
 		logging.Log("Using kubeconfig from environment variable location KUBECONFIG=%s", os.Getenv("KUBE_CONFIG"))
 	} else {
 		logging.Log("No KUBECONFIG found in environment, assumi we are in cluster, using in-cluster client config.")
-		config, err = rest.InClusterConfig()
+		kubeConfig, err = rest.InClusterConfig()
 		if err != nil {
 			logging.Fatal("Could not load in-cluster config: %v", err)
 		}
 	}
 
-	clientSet, err := kubernetes.NewForConfig(config)
+	clientSet, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
 		logging.Fatal("Error initialising Kubernetes Node Client based on kubeconfig: %v", err)
 	}
 
+	// Custom pod controllers are watched and patched through the dynamic client
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		logging.Fatal("Error initialising Kubernetes dynamic client based on kubeconfig: %v", err)
+	}
+
 	stopChan := make(chan struct{})
 	defer close(stopChan)
 
 	// Start controllers
-	controllers.Init(clientSet, stopChan, resyncInterval)
+	controllers.Init(clientSet, dynamicClient, stopChan, resyncInterval)
 	logging.Log("Started all controllers")
 
+	// Serve metrics, including for followers when running with leader election
+	go metrics.Serve(config.Config.MetricsAddress)
+
+	// Start the processor, which schedules control loops restarting pod controllers.
+	// With leader election, it only runs while this replica is the leader.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processor.Init(clientSet, dynamicClient)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if config.Config.LeaderElection {
+			election.Run(ctx, clientSet, processor.Run)
+			return
+		}
+		processor.Run(ctx)
+	}()
+	logging.Log("Started processor")
+
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, syscall.SIGINT, syscall.SIGTERM)
 
 	// Run forever (until interrupted)
-	select {
-	case <-osSignals:
-		stopChan <- stop{}
-	default:
-	}
+	<-osSignals
+	logging.Log("Received signal, shutting down")
+
+	// Wait for the processor to stop, which also releases leadership if held
+	cancel()
+	<-done
 }
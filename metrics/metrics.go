@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/chongyangshi/Order/logging"
+)
+
+const namespace = "order"
+
+const (
+	// SkipReasonCooldown is when a pod controller was restarted too recently
+	SkipReasonCooldown = "cooldown"
+	// SkipReasonBlacklist is when a pod controller is excluded by whitelisted or
+	// blacklisted controllers of a managed resource
+	SkipReasonBlacklist = "blacklist"
+	// SkipReasonDisruptionBudget is when a PodDisruptionBudget selecting pods of a pod
+	// controller allows no disruptions
+	SkipReasonDisruptionBudget = "disruption_budget"
+)
+
+const (
+	// PendingStateReady is when a pending restart can be dispatched once the stagger
+	// since the last restart has passed
+	PendingStateReady = "ready"
+	// PendingStateRollout is when a pending restart is held until earlier rollouts for
+	// the same changes become healthy
+	PendingStateRollout = "rollout"
+	// PendingStateWindow is when a pending restart waits for a maintenance window to
+	// open, or for a blackout to end
+	PendingStateWindow = "window"
+	// PendingStateDisruptionBudget is when a pending restart waits for a
+	// PodDisruptionBudget to allow disruptions
+	PendingStateDisruptionBudget = "disruption_budget"
+)
+
+const (
+	// InformerRoleCache is the role of informers caching pod controllers and other
+	// resources Order reads
+	InformerRoleCache = "cache"
+	// InformerRoleManagedResource is the role of informers watching managed resources
+	// for changes
+	InformerRoleManagedResource = "managed_resource"
+)
+
+var (
+	leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this replica of Order is the leader restarting pod controllers.",
+	})
+
+	leadershipTransitions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "leadership_transitions_total",
+		Help:      "Number of times this replica of Order acquired or lost leadership.",
+	})
+
+	restarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "restarts_total",
+		Help:      "Number of rolling restarts performed on pod controllers.",
+	}, []string{"namespace", "kind"})
+
+	dryRunRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dry_run_restarts_total",
+		Help:      "Number of rolling restarts which would have been performed on pod controllers outside dry run.",
+	}, []string{"namespace", "kind"})
+
+	restartsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "restarts_skipped_total",
+		Help:      "Number of changes in managed resources for which a pod controller was not restarted, or its restart deferred, by reason.",
+	}, []string{"namespace", "kind", "reason"})
+
+	controlLoopDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "control_loop_duration_seconds",
+		Help:      "Duration of control loops reconciling all pod controllers.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	})
+
+	controlLoopErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "control_loop_errors_total",
+		Help:      "Number of control loops which failed to reconcile some pod controllers.",
+	})
+
+	pendingRestarts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_restarts",
+		Help:      "Number of rolling restarts queued and not yet dispatched, by state.",
+	}, []string{"state"})
+
+	nextWindowOpening = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_restarts_next_window_timestamp_seconds",
+		Help:      "Unix time at which the earliest pending restart waiting for a maintenance window may be dispatched, or 0 if none is waiting.",
+	})
+
+	managedResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "managed_resources",
+		Help:      "Number of managed resources in config found in the cluster, and of entries in config matching none, as of the last reconciliation.",
+	}, []string{"state"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		leader,
+		leadershipTransitions,
+		restarts,
+		dryRunRestarts,
+		restartsSkipped,
+		controlLoopDuration,
+		controlLoopErrors,
+		pendingRestarts,
+		nextWindowOpening,
+		managedResources,
+	)
+}
+
+// Serve exposes metrics at /metrics on the address. It blocks until the server fails,
+// which is logged but otherwise doesn't affect Order.
+func Serve(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	logging.Log("Serving metrics on %s/metrics", address)
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		logging.Log("Error serving metrics on %s: %v", address, err)
+	}
+}
+
+// SetLeader records whether this replica is currently the leader, counting a transition
+// if it has changed.
+func SetLeader(isLeader bool) {
+	leadershipTransitions.Inc()
+	if isLeader {
+		leader.Set(1)
+		return
+	}
+	leader.Set(0)
+}
+
+// RestartPerformed counts a rolling restart performed on a pod controller.
+func RestartPerformed(namespace, kind string) {
+	restarts.WithLabelValues(namespace, kind).Inc()
+}
+
+// DryRunRestart counts a rolling restart which would have been performed on a pod
+// controller outside dry run.
+func DryRunRestart(namespace, kind string) {
+	dryRunRestarts.WithLabelValues(namespace, kind).Inc()
+}
+
+// RestartSkipped counts a change in managed resources for which a pod controller was not
+// restarted, or its restart deferred, for the reason. It is counted once for each change
+// rather than in every control loop.
+func RestartSkipped(namespace, kind, reason string) {
+	restartsSkipped.WithLabelValues(namespace, kind, reason).Inc()
+}
+
+// ObserveControlLoop records the duration of a control loop, and whether it failed.
+func ObserveControlLoop(duration time.Duration, err error) {
+	controlLoopDuration.Observe(duration.Seconds())
+	if err != nil {
+		controlLoopErrors.Inc()
+	}
+}
+
+// SetPendingRestarts records how many rolling restarts are pending in each state, and
+// when the earliest of those waiting for a maintenance window may be dispatched, which is
+// zero if none is waiting.
+func SetPendingRestarts(ready, rollout, window, disruptionBudget int, nextWindow time.Time) {
+	pendingRestarts.WithLabelValues(PendingStateReady).Set(float64(ready))
+	pendingRestarts.WithLabelValues(PendingStateRollout).Set(float64(rollout))
+	pendingRestarts.WithLabelValues(PendingStateWindow).Set(float64(window))
+	pendingRestarts.WithLabelValues(PendingStateDisruptionBudget).Set(float64(disruptionBudget))
+
+	if nextWindow.IsZero() {
+		nextWindowOpening.Set(0)
+		return
+	}
+	nextWindowOpening.Set(float64(nextWindow.Unix()))
+}
+
+// SetManagedResources records how many managed resources in config were found in the
+// cluster, and how many entries in config matched none.
+func SetManagedResources(found, missing int) {
+	managedResources.WithLabelValues("found").Set(float64(found))
+	managedResources.WithLabelValues("missing").Set(float64(missing))
+}
+
+// RegisterInformer exposes whether the informer of a cache or managed resource
+// controller has synced. The same kind of resource may be watched in both roles, such
+// as a custom resource both managed and restarted as a pod controller.
+func RegisterInformer(role, name string, synced func() bool) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "informer_synced",
+		Help:        "Whether the informer of a controller has synced.",
+		ConstLabels: prometheus.Labels{"role": role, "informer": name},
+	}, func() float64 {
+		if synced() {
+			return 1
+		}
+		return 0
+	}))
+}
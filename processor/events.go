@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventComponent = "order"
+
+	// eventReasonRollingRestart is recorded on pod controllers restarted by Order, and on
+	// managed resources whose changes caused the restarts
+	eventReasonRollingRestart = "RollingRestart"
+	// eventReasonDryRunRollingRestart is recorded instead in dry run, on pod controllers
+	// Order would have restarted
+	eventReasonDryRunRollingRestart = "DryRunRollingRestart"
+	// eventReasonRestartDeferred is recorded on pod controllers requiring a restart which
+	// is held back by their restart cooldown
+	eventReasonRestartDeferred = "RollingRestartDeferred"
+	// eventReasonRestartBlocked is recorded when a change in a managed resource does not
+	// restart pod controllers due to namespaces in config, or whitelisted or blacklisted
+	// controllers of the managed resource
+	eventReasonRestartBlocked = "RollingRestartBlocked"
+	// eventReasonOutOfDate is recorded on Jobs which are out of date with their managed
+	// resources, but which their job policy does not allow Order to re-create
+	eventReasonOutOfDate = "ManagedResourcesOutOfDate"
+	// eventReasonRecreateFailed is recorded on Jobs which were deleted to be re-created
+	// with changed managed resources, but could not be created again
+	eventReasonRecreateFailed = "RecreateFailed"
+	// eventReasonRolloutFailed is recorded on pod controllers whose rollouts have failed
+	// after a restart by Order, and on managed resources whose changes caused them
+	eventReasonRolloutFailed = "RolloutFailed"
+	// eventReasonRolledBack is recorded on pod controllers rolled back after a failed
+	// rollout
+	eventReasonRolledBack = "RolledBack"
+)
+
+// recorder records Kubernetes Events explaining what Order does to pod controllers.
+var recorder record.EventRecorder
+
+// newEventRecorder returns an event recorder sending Events to the cluster.
+func newEventRecorder(c kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.CoreV1().Events("")})
+
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+}
+
+// recordEvent records an Event on a pod controller or managed resource, if the processor
+// has been initialised with an event recorder.
+func recordEvent(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if recorder == nil || object == nil {
+		return
+	}
+
+	recorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+// describeResources returns a human readable list of managed resources for Events.
+func describeResources(resources []*managedResource) string {
+	var descriptions []string
+	for _, r := range resources {
+		descriptions = append(descriptions, r.getDescription())
+	}
+
+	if len(descriptions) == 0 {
+		return "managed resources"
+	}
+
+	return strings.Join(descriptions, ", ")
+}
+
+// describePodController returns a human readable identifier of a pod controller for Events.
+func describePodController(c podController) string {
+	meta := c.getObjectMeta()
+	return fmt.Sprintf("%s %s/%s", c.getKind(), meta.GetNamespace(), meta.GetName())
+}
@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// lastAppliedConfigAnnotation is maintained by kubectl apply, and mirrors content we
+// already hash, while changing with formatting of the applied manifest.
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// writeContent writes a canonical representation of the content of a managed resource
+// to the hasher. Unless configured otherwise, labels and annotations are included, with
+// the exception of annotations managed by Order itself. For custom resources, only the
+// configured hash field or their generation is written.
+func writeContent(hasher hash.Hash, r managedResource) {
+	if r.custom != nil {
+		writeCustomContent(hasher, r)
+		return
+	}
+
+	var labels, annotations map[string]string
+	switch {
+	case r.secret != nil:
+		writeEntry(hasher, "type", "", []byte(r.secret.Type))
+		writeBytesMap(hasher, "data", r.secret.Data)
+		writeStringMap(hasher, "stringData", r.secret.StringData)
+		labels, annotations = r.secret.Labels, r.secret.Annotations
+
+	case r.configMap != nil:
+		writeStringMap(hasher, "data", r.configMap.Data)
+		writeBytesMap(hasher, "binaryData", r.configMap.BinaryData)
+		labels, annotations = r.configMap.Labels, r.configMap.Annotations
+	}
+
+	if config.Config.HashIgnoreMetadata {
+		return
+	}
+
+	writeStringMap(hasher, "labels", labels)
+
+	filteredAnnotations := map[string]string{}
+	for key, value := range annotations {
+		if key == lastAppliedConfigAnnotation || strings.HasPrefix(key, proto.LabelPrefix+"/") {
+			continue
+		}
+		filteredAnnotations[key] = value
+	}
+	writeStringMap(hasher, "annotations", filteredAnnotations)
+}
+
+// writeCustomContent writes the configured hash field of a custom resource to the hasher,
+// or its generation if none is configured, which only changes with its spec.
+func writeCustomContent(hasher hash.Hash, r managedResource) {
+	if r.config == nil || len(r.config.XXXParsedHashField) == 0 {
+		writeEntry(hasher, "generation", "", []byte(strconv.FormatInt(r.custom.GetGeneration(), 10)))
+		return
+	}
+
+	value, found, err := unstructured.NestedFieldNoCopy(r.custom.Object, r.config.XXXParsedHashField...)
+	if err != nil || !found {
+		// Distinguish a missing field from an empty one
+		writeEntry(hasher, "missing", r.config.HashField, nil)
+		return
+	}
+
+	// Maps are marshalled with sorted keys, so this is canonical.
+	content, err := json.Marshal(value)
+	if err != nil {
+		writeEntry(hasher, "missing", r.config.HashField, nil)
+		return
+	}
+	writeEntry(hasher, "field", r.config.HashField, content)
+}
+
+func writeStringMap(hasher hash.Hash, section string, m map[string]string) {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		writeEntry(hasher, section, key, []byte(m[key]))
+	}
+}
+
+func writeBytesMap(hasher hash.Hash, section string, m map[string][]byte) {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		writeEntry(hasher, section, key, m[key])
+	}
+}
+
+// writeEntry writes a length-prefixed entry to the hasher, so that different contents
+// can't be written identically.
+func writeEntry(hasher hash.Hash, section, key string, value []byte) {
+	fmt.Fprintf(hasher, "%d:%s:%d:%s:%d:", len(section), section, len(key), key, len(value))
+	hasher.Write(value)
+}
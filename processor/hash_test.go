@@ -0,0 +1,170 @@
+package processor
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/proto"
+)
+
+func testConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "app",
+			Namespace:       "default",
+			UID:             "uid",
+			ResourceVersion: "1",
+			Generation:      1,
+			Labels:          map[string]string{"app": "web"},
+			Annotations:     map[string]string{"owner": "team-a"},
+		},
+		Data:       map[string]string{"app.yaml": "replicas: 3", "log-level": "info"},
+		BinaryData: map[string][]byte{"logo.png": {0x89, 0x50}},
+	}
+}
+
+func TestGetContentHash(t *testing.T) {
+	tests := []struct {
+		name               string
+		hashIgnoreMetadata bool
+		edit               func(cm *corev1.ConfigMap)
+		expectChange       bool
+	}{
+		{"no-op update", false, func(cm *corev1.ConfigMap) {
+			cm.ResourceVersion = "2"
+		}, false},
+		{"re-created with identical content", false, func(cm *corev1.ConfigMap) {
+			cm.UID = "other-uid"
+			cm.ResourceVersion = "100"
+		}, false},
+		{"managed fields and timestamps", false, func(cm *corev1.ConfigMap) {
+			cm.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}
+			cm.CreationTimestamp = metav1.Now()
+		}, false},
+		{"last applied configuration", false, func(cm *corev1.ConfigMap) {
+			cm.Annotations[lastAppliedConfigAnnotation] = `{"data":{}}`
+		}, false},
+		{"annotation managed by Order", false, func(cm *corev1.ConfigMap) {
+			cm.Annotations[annotationKey(proto.LabelPoisonedHash)] = "abc"
+		}, false},
+		{"label changed", false, func(cm *corev1.ConfigMap) {
+			cm.Labels["app"] = "api"
+		}, true},
+		{"annotation added", false, func(cm *corev1.ConfigMap) {
+			cm.Annotations["note"] = "hello"
+		}, true},
+		{"label changed ignoring metadata", true, func(cm *corev1.ConfigMap) {
+			cm.Labels["app"] = "api"
+		}, false},
+		{"annotations removed ignoring metadata", true, func(cm *corev1.ConfigMap) {
+			cm.Annotations = nil
+		}, false},
+		{"data changed ignoring metadata", true, func(cm *corev1.ConfigMap) {
+			cm.Data["log-level"] = "debug"
+		}, true},
+		{"data changed", false, func(cm *corev1.ConfigMap) {
+			cm.Data["log-level"] = "debug"
+		}, true},
+		{"binary data changed", false, func(cm *corev1.ConfigMap) {
+			cm.BinaryData["logo.png"] = []byte{0x89}
+		}, true},
+		{"key moved between data and binary data", false, func(cm *corev1.ConfigMap) {
+			delete(cm.Data, "log-level")
+			cm.BinaryData["log-level"] = []byte("info")
+		}, true},
+		{"key renamed", false, func(cm *corev1.ConfigMap) {
+			delete(cm.Data, "log-level")
+			cm.Data["loglevel"] = "info"
+		}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Config = &proto.OrderConfig{HashIgnoreMetadata: test.hashIgnoreMetadata}
+
+			cm := testConfigMap()
+			before := managedResource{configMap: cm}.getContentHash()
+
+			edited := testConfigMap()
+			test.edit(edited)
+			after := managedResource{configMap: edited}.getContentHash()
+
+			if changed := before != after; changed != test.expectChange {
+				t.Errorf("Expected hash to change: %v, got %s before and %s after", test.expectChange, before, after)
+			}
+		})
+	}
+}
+
+func TestGetContentHashSecret(t *testing.T) {
+	config.Config = &proto.OrderConfig{}
+
+	secret := func(secretType corev1.SecretType, stringData map[string]string) managedResource {
+		return managedResource{secret: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+			Type:       secretType,
+			Data:       map[string][]byte{"password": []byte("hunter2")},
+			StringData: stringData,
+		}}
+	}
+
+	base := secret(corev1.SecretTypeOpaque, nil).getContentHash()
+	if secret(corev1.SecretTypeOpaque, nil).getContentHash() != base {
+		t.Errorf("Expected hash to be stable for the same content")
+	}
+	if secret(corev1.SecretTypeBasicAuth, nil).getContentHash() == base {
+		t.Errorf("Expected hash to change with Secret type")
+	}
+	if secret(corev1.SecretTypeOpaque, map[string]string{"password": "hunter2"}).getContentHash() == base {
+		t.Errorf("Expected hash to change with string data")
+	}
+}
+
+func TestGetContentHashCustom(t *testing.T) {
+	config.Config = &proto.OrderConfig{}
+
+	custom := func(generation int64, revision interface{}, labels map[string]string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Certificate",
+			"metadata":   map[string]interface{}{"name": "tls", "namespace": "default"},
+		}}
+		u.SetGeneration(generation)
+		u.SetLabels(labels)
+		if revision != nil {
+			_ = unstructured.SetNestedField(u.Object, revision, "status", "revision")
+		}
+		return u
+	}
+
+	byGeneration := &proto.ManagedResource{Type: proto.ManagedResourceTypeCustom}
+	byField := &proto.ManagedResource{Type: proto.ManagedResourceTypeCustom, HashField: ".status.revision", XXXParsedHashField: []string{"status", "revision"}}
+
+	tests := []struct {
+		name         string
+		config       *proto.ManagedResource
+		before       *unstructured.Unstructured
+		after        *unstructured.Unstructured
+		expectChange bool
+	}{
+		{"generation unchanged on metadata edit", byGeneration, custom(1, nil, nil), custom(1, nil, map[string]string{"a": "b"}), false},
+		{"generation changed", byGeneration, custom(1, nil, nil), custom(2, nil, nil), true},
+		{"hash field unchanged on spec edit", byField, custom(1, int64(3), nil), custom(2, int64(3), nil), false},
+		{"hash field changed", byField, custom(1, int64(3), nil), custom(1, int64(4), nil), true},
+		{"hash field appeared", byField, custom(1, nil, nil), custom(1, int64(0), nil), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := managedResource{custom: test.before, config: test.config}.getContentHash()
+			after := managedResource{custom: test.after, config: test.config}.getContentHash()
+			if changed := before != after; changed != test.expectChange {
+				t.Errorf("Expected hash to change: %v, got %s before and %s after", test.expectChange, before, after)
+			}
+		})
+	}
+}
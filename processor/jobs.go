@@ -0,0 +1,149 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// jobDeletionTimeout is how long we wait for a Job to be deleted before re-creating it.
+const jobDeletionTimeout = time.Second * 30
+
+// jobCreateBackoff is how we retry creating a Job again after deleting it, as nothing
+// references a deleted Job to re-create it later.
+var jobCreateBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Steps:    8,
+	Cap:      time.Minute,
+}
+
+// jobGeneratedLabels are set by Kubernetes on Jobs and their pod templates based on the
+// UID and name of the Job, which must not be carried over to a re-created Job.
+var jobGeneratedLabels = []string{
+	"controller-uid",
+	"job-name",
+	"batch.kubernetes.io/controller-uid",
+	"batch.kubernetes.io/job-name",
+}
+
+// recreateJob deletes a standalone Job and creates it again from its spec, as Job pod
+// templates are immutable. The new Job records the managed resources hash and versions,
+// and is otherwise the same as the old one.
+func recreateJob(ctx context.Context, c podController, hash, versions string) error {
+	if c.job == nil {
+		return nil
+	}
+
+	old := c.job
+	restartedAt := time.Now().UTC()
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        old.Name,
+			Namespace:   old.Namespace,
+			Labels:      copyStringMap(old.Labels),
+			Annotations: copyStringMap(old.Annotations),
+		},
+		Spec: *old.Spec.DeepCopy(),
+	}
+	job.Annotations[annotationKey(proto.LabelManagedResourcesHash)] = hash
+	job.Annotations[annotationKey(proto.LabelManagedResourceVersions)] = versions
+	job.Annotations[annotationKey(proto.LabelLastRollingRestart)] = restartedAt.Format(time.RFC3339)
+
+	if job.Spec.Template.Annotations == nil {
+		job.Spec.Template.Annotations = map[string]string{}
+	}
+	job.Spec.Template.Annotations[annotationKey(proto.LabelLastRollingRestart)] = restartedAt.Format(time.RFC3339)
+
+	// Unless the selector was set manually, let Kubernetes generate it for the new Job
+	if job.Spec.ManualSelector == nil || !*job.Spec.ManualSelector {
+		job.Spec.Selector = nil
+		for _, label := range jobGeneratedLabels {
+			delete(job.Labels, label)
+			delete(job.Spec.Template.Labels, label)
+		}
+	}
+
+	logging.Log("Re-creating %s as its managed resources have changed", c.getKey())
+
+	uid := old.UID
+	propagationPolicy := metav1.DeletePropagationBackground
+	err := clientSet.BatchV1().Jobs(old.Namespace).Delete(ctx, old.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+		Preconditions:     &metav1.Preconditions{UID: &uid},
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	// Once deleted, nothing references the Job any more, and later loops will never
+	// notice if it is not created again. So we carry on re-creating it from here even if
+	// we are shutting down or losing leadership.
+	ctx = context.WithoutCancel(ctx)
+
+	// Wait for the old Job to be gone, as the new Job has the same name. If it takes
+	// longer, creating the new Job is retried below until it is gone.
+	err = wait.PollUntilContextTimeout(ctx, time.Second, jobDeletionTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := clientSet.BatchV1().Jobs(old.Namespace).Get(ctx, old.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		logging.Log("Timed out waiting for %s to be deleted, re-creating it anyway: %v", c.getKey(), err)
+	}
+
+	err = retry.OnError(jobCreateBackoff, func(err error) bool {
+		return !errors.IsInvalid(err)
+	}, func() error {
+		_, err := clientSet.BatchV1().Jobs(job.Namespace).Create(ctx, job, metav1.CreateOptions{})
+		return err
+	})
+	if err != nil {
+		logging.Log("Error re-creating %s after deleting it: %v", c.getKey(), err)
+		recordEvent(old, corev1.EventTypeWarning, eventReasonRecreateFailed, "Deleted to re-create as managed resources have changed, but failed to create it again: %v", err)
+		return err
+	}
+
+	recentRestartsLock.Lock()
+	recentRestarts[c.getKey()] = restartedAt
+	recentRestartsLock.Unlock()
+
+	return nil
+}
+
+// isJobFinished returns whether a Job has completed or failed.
+func isJobFinished(job *batchv1.Job) bool {
+	if job.Status.CompletionTime != nil {
+		return true
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	copied := map[string]string{}
+	for key, value := range m {
+		copied[key] = value
+	}
+
+	return copied
+}
@@ -3,25 +3,40 @@ package processor
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/chongyangshi/Order/config"
 	"github.com/chongyangshi/Order/controllers"
+	"github.com/chongyangshi/Order/controllers/configmaps"
+	"github.com/chongyangshi/Order/controllers/secrets"
 	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
 	"github.com/chongyangshi/Order/proto"
 )
 
 // managedResource represent a resource which can be monitored for change by Order.
-// The underlying supported types may be subject to future extension. Currently Secret
-// and ConfigMap are supported.
+// The underlying supported types may be subject to future extension. Currently Secret,
+// ConfigMap and custom resources are supported.
 type managedResource struct {
 	secret    *corev1.Secret
 	configMap *corev1.ConfigMap
+	custom    *unstructured.Unstructured
 	config    *proto.ManagedResource
+
+	// ownedSecrets are Secrets owned by a custom resource, through which it applies to
+	// pod controllers referencing them
+	ownedSecrets []*corev1.Secret
 }
 
 func (r managedResource) getUID() string {
@@ -30,21 +45,231 @@ func (r managedResource) getUID() string {
 		return string(r.secret.GetUID())
 	case r.configMap != nil:
 		return string(r.configMap.GetUID())
+	case r.custom != nil:
+		return string(r.custom.GetUID())
+	}
+
+	return ""
+}
+
+func (r managedResource) getNamespace() string {
+	switch {
+	case r.secret != nil:
+		return r.secret.Namespace
+	case r.configMap != nil:
+		return r.configMap.Namespace
+	case r.custom != nil:
+		return r.custom.GetNamespace()
+	}
+
+	return ""
+}
+
+// getVersion returns a string identifying the version of the managed resource consumed
+// by a pod controller, based on its content rather than its resource version, so that
+// no-op updates don't change its version. If the pod controller only consumes some of
+// its keys, only changes in the content of those keys change its version. Custom
+// resources have no keys.
+func (r managedResource) getVersion(keys *proto.KeyReferences) string {
+	return fmt.Sprintf("%s:%s", r.getDescription(), r.getVersionHash(keys))
+}
+
+// getVersionHash returns a hex encoded hash over the content of the managed resource
+// consumed by a pod controller, which is the part of its version following its
+// description.
+func (r managedResource) getVersionHash(keys *proto.KeyReferences) string {
+	if keys == nil || keys.All || r.custom != nil {
+		return r.getContentHash()
+	}
+
+	hasher := sha256.New()
+	for _, key := range keys.SortedKeys() {
+		value, found := r.getValue(key)
+		if !found {
+			// Distinguish a missing key from an empty one
+			writeEntry(hasher, "missing", key, nil)
+			continue
+		}
+		writeEntry(hasher, "key", key, value)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// getContentHash returns a hex encoded hash over the whole content of the managed
+// resource.
+func (r managedResource) getContentHash() string {
+	hasher := sha256.New()
+	writeContent(hasher, r)
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// getAnnotation returns the value of an annotation managed by Order on the managed
+// resource, and whether it is set at all.
+func (r managedResource) getAnnotation(label string) (string, bool) {
+	var annotations map[string]string
+	switch {
+	case r.secret != nil:
+		annotations = r.secret.Annotations
+	case r.configMap != nil:
+		annotations = r.configMap.Annotations
+	case r.custom != nil:
+		annotations = r.custom.GetAnnotations()
+	}
+
+	value, found := annotations[annotationKey(label)]
+	return value, found
+}
+
+// getValue returns the content of a key in the managed resource, and whether it exists.
+func (r managedResource) getValue(key string) ([]byte, bool) {
+	switch {
+	case r.secret != nil:
+		if value, found := r.secret.Data[key]; found {
+			return value, true
+		}
+		if value, found := r.secret.StringData[key]; found {
+			return []byte(value), true
+		}
+
+	case r.configMap != nil:
+		if value, found := r.configMap.Data[key]; found {
+			return []byte(value), true
+		}
+		if value, found := r.configMap.BinaryData[key]; found {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// getObject returns the underlying object of the managed resource, such as for recording
+// Events on it.
+func (r managedResource) getObject() runtime.Object {
+	switch {
+	case r.secret != nil:
+		return r.secret
+	case r.configMap != nil:
+		return r.configMap
+	case r.custom != nil:
+		return r.custom
+	}
+
+	return nil
+}
+
+// getJobPolicy returns what to do with standalone Jobs referencing the managed resource.
+func (r managedResource) getJobPolicy() string {
+	if r.config == nil || r.config.JobPolicy == "" {
+		return proto.JobPolicyIgnore
+	}
+
+	return r.config.JobPolicy
+}
+
+// getDescription returns a human readable identifier of the managed resource.
+func (r managedResource) getDescription() string {
+	switch {
+	case r.secret != nil:
+		return fmt.Sprintf("Secret %s/%s", r.secret.Namespace, r.secret.Name)
+	case r.configMap != nil:
+		return fmt.Sprintf("ConfigMap %s/%s", r.configMap.Namespace, r.configMap.Name)
+	case r.custom != nil:
+		return fmt.Sprintf("%s %s/%s", r.custom.GetKind(), r.custom.GetNamespace(), r.custom.GetName())
 	}
 
 	return ""
 }
 
+// matches returns whether the managed resource is the changed resource taken from a
+// work queue.
+func (r managedResource) matches(c changedResource) bool {
+	identity, ok := r.asChangedResource()
+	return ok && identity == c
+}
+
+// asChangedResource returns how the managed resource is identified when taken from a
+// work queue after it changed.
+func (r managedResource) asChangedResource() (changedResource, bool) {
+	switch {
+	case r.secret != nil:
+		return changedResource{resourceType: proto.ManagedResourceTypeSecrets, namespace: r.secret.Namespace, name: r.secret.Name}, true
+	case r.configMap != nil:
+		return changedResource{resourceType: proto.ManagedResourceTypeConfigMaps, namespace: r.configMap.Namespace, name: r.configMap.Name}, true
+	case r.custom != nil:
+		return changedResource{resourceType: r.custom.GetKind(), namespace: r.custom.GetNamespace(), name: r.custom.GetName()}, true
+	}
+
+	return changedResource{}, false
+}
+
+// permits returns whether the managed resource's config permits Order to restart the
+// pod controller, based on its whitelisted and blacklisted controllers. If whitelisted
+// controllers are set, blacklisted controllers are not consulted.
+func (r managedResource) permits(c podController) bool {
+	if r.config == nil {
+		return true
+	}
+
+	meta := c.getObjectMeta()
+	namespace := r.getNamespace()
+	if len(r.config.WhitelistedControllers) > 0 {
+		for _, whitelisted := range r.config.WhitelistedControllers {
+			if whitelisted.Matches(c.getKind(), meta.GetNamespace(), meta.GetName(), namespace) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, blacklisted := range r.config.BlacklistedControllers {
+		if blacklisted.Matches(c.getKind(), meta.GetNamespace(), meta.GetName(), namespace) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (r managedResource) exists() bool {
 	switch {
 	case r.secret != nil,
-		r.configMap != nil:
+		r.configMap != nil,
+		r.custom != nil:
 		return true
 	}
 
 	return false
 }
 
+// linksTo returns whether a custom managed resource applies to the pod controller, either
+// by nominating it in pod_controllers, or by owning a Secret which it references.
+func (r managedResource) linksTo(c podController) bool {
+	if r.custom == nil {
+		return false
+	}
+
+	if r.config != nil {
+		meta := c.getObjectMeta()
+		for _, nominated := range r.config.PodControllers {
+			if nominated.Matches(c.getKind(), meta.GetNamespace(), meta.GetName(), r.getNamespace()) {
+				return true
+			}
+		}
+	}
+
+	for _, secret := range r.ownedSecrets {
+		if c.hasReference(&managedResource{secret: secret}) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // A batch query to map managed resources specified in config to resources which actually
 // exist in the cluster at any given point in time. We can't just run this once and keep
 // a cache of results, as managed resources can be added or deleted between runs.
@@ -64,93 +289,432 @@ func getManagedResourcesInConfig() ([]managedResource, error) {
 	}
 
 	var resources []managedResource
+	missing := 0
+	seen := map[types.UID]bool{}
 	for _, resource := range config.Config.ManagedResources {
 		if resource == nil {
 			// Should never happen
 			continue
 		}
 
-		r := managedResource{}
-
+		// As names and namespaces can be patterns, each managed resource in config may
+		// expand to any number of resources in the cluster. If a resource is matched by
+		// more than one managed resource in config, the first one applies.
+		// If a selector is set, we only consider resources matching it.
+		var matched []managedResource
 		switch resource.Type {
 		case proto.ManagedResourceTypeSecrets:
-			secret := findSecretByReference(secrets, resource.Name, resource.Namespace)
-			if secret == nil {
-				logging.Debug("Managed Secret %s of namespace %s not found in controller cache", resource.Name, resource.Namespace)
-				break
+			candidates := secrets
+			if resource.XXXParsedSelector != nil {
+				candidates, err = controllers.GetSecretsBySelector(resource.XXXParsedSelector)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			for _, secret := range findSecretsByReference(candidates, resource.Name, resource.Namespace) {
+				matched = append(matched, managedResource{secret: secret})
 			}
-			r.secret = secret
 
 		case proto.ManagedResourceTypeConfigMaps:
-			configMap := findConfigMapByReference(configMaps, resource.Name, resource.Namespace)
-			if configMap == nil {
-				logging.Debug("Managed ConfigMap %s of namespace %s not found in controller cache", resource.Name, resource.Namespace)
-				break
+			candidates := configMaps
+			if resource.XXXParsedSelector != nil {
+				candidates, err = controllers.GetConfigMapsBySelector(resource.XXXParsedSelector)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			for _, configMap := range findConfigMapsByReference(candidates, resource.Name, resource.Namespace) {
+				matched = append(matched, managedResource{configMap: configMap})
+			}
+
+		case proto.ManagedResourceTypeCustom:
+			selector := resource.XXXParsedSelector
+			if selector == nil {
+				selector = labels.Everything()
+			}
+
+			candidates, err := controllers.GetCustomResourcesBySelector(resource.GroupVersionKind(), selector)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, custom := range findCustomResourcesByReference(candidates, resource.Name, resource.Namespace) {
+				matched = append(matched, managedResource{
+					custom:       custom,
+					ownedSecrets: findSecretsOwnedBy(secrets, custom),
+				})
 			}
-			r.configMap = configMap
 
 		default:
 			logging.Debug("Unsupported managed resource type %s, this should not have passed validation.", resource.Type)
+			continue
+		}
+
+		if len(matched) == 0 {
+			logging.Debug("Managed %s %s of namespace %s with selector %s not found in controller cache", resource.Type, resource.Name, resource.Namespace, resource.Selector)
+			missing++
+			continue
 		}
 
-		if r.exists() {
+		for _, r := range matched {
+			uid := types.UID(r.getUID())
+			if seen[uid] {
+				continue
+			}
+			seen[uid] = true
+
 			r.config = resource
 			resources = append(resources, r)
 		}
 	}
+	metrics.SetManagedResources(len(resources), missing)
 
 	return resources, nil
 }
 
-func findSecretByReference(secrets []*corev1.Secret, name, namespace string) *corev1.Secret {
+// getManagedResourcesInAnnotation looks up managed resources which a pod controller opts
+// into through its watch annotation, which exist in the cluster at this point in time.
+func getManagedResourcesInAnnotation(c podController) ([]*managedResource, error) {
+	value, found := c.getAnnotation(proto.LabelWatch)
+	if !found {
+		return nil, nil
+	}
+
+	namespace := c.getObjectMeta().GetNamespace()
+	resourceConfigs, err := config.Config.ParseWatchAnnotation(value, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookupManagedResources(c, resourceConfigs)
+}
+
+// getManagedResourcesAutoDiscovered looks up all Secrets and ConfigMaps referenced by a
+// pod controller's pod template which exist in the cluster at this point in time, if
+// auto discovery is enabled for its namespace.
+func getManagedResourcesAutoDiscovered(c podController) ([]*managedResource, error) {
+	namespace := c.getObjectMeta().GetNamespace()
+	if !config.Config.AutoDiscoverEnabled(namespace) {
+		return nil, nil
+	}
+
+	var resourceConfigs []*proto.ManagedResource
+	podSpec := c.getPodSpec()
+	for _, name := range secrets.PodSpecReferences(podSpec) {
+		resourceConfigs = append(resourceConfigs, config.Config.NewDefaultManagedResource(proto.ManagedResourceTypeSecrets, name, namespace))
+	}
+	for _, name := range configmaps.PodSpecReferences(podSpec) {
+		resourceConfigs = append(resourceConfigs, config.Config.NewDefaultManagedResource(proto.ManagedResourceTypeConfigMaps, name, namespace))
+	}
+
+	return lookupManagedResources(c, resourceConfigs)
+}
+
+// lookupManagedResources looks up managed resources for a pod controller which are not
+// listed in config, skipping those which don't exist in the cluster.
+func lookupManagedResources(c podController, resourceConfigs []*proto.ManagedResource) ([]*managedResource, error) {
+	var resources []*managedResource
+	for _, resourceConfig := range resourceConfigs {
+		r := &managedResource{config: resourceConfig}
+
+		switch resourceConfig.Type {
+		case proto.ManagedResourceTypeSecrets:
+			secret, err := controllers.GetSecret(resourceConfig.Namespace, resourceConfig.Name)
+			if errors.IsNotFound(err) {
+				logging.Debug("Secret %s managed for %s not found in controller cache", resourceConfig.Name, c.getKey())
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			r.secret = secret
+
+		case proto.ManagedResourceTypeConfigMaps:
+			configMap, err := controllers.GetConfigMap(resourceConfig.Namespace, resourceConfig.Name)
+			if errors.IsNotFound(err) {
+				logging.Debug("ConfigMap %s managed for %s not found in controller cache", resourceConfig.Name, c.getKey())
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			r.configMap = configMap
+		}
+
+		resources = append(resources, r)
+	}
+
+	return resources, nil
+}
+
+func findSecretsByReference(secrets []*corev1.Secret, name, namespace string) []*corev1.Secret {
+	var results []*corev1.Secret
 	for _, secret := range secrets {
-		if secret.Name == name && secret.Namespace == namespace {
-			return secret
+		if proto.MatchesNamePattern(name, secret.Name) && proto.MatchesNamePattern(namespace, secret.Namespace) {
+			results = append(results, secret)
 		}
 	}
 
-	return nil
+	return results
 }
 
-func findConfigMapByReference(configMaps []*corev1.ConfigMap, name, namespace string) *corev1.ConfigMap {
+func findConfigMapsByReference(configMaps []*corev1.ConfigMap, name, namespace string) []*corev1.ConfigMap {
+	var results []*corev1.ConfigMap
 	for _, configMap := range configMaps {
-		if configMap.Name == name && configMap.Namespace == namespace {
-			return configMap
+		if proto.MatchesNamePattern(name, configMap.Name) && proto.MatchesNamePattern(namespace, configMap.Namespace) {
+			results = append(results, configMap)
 		}
 	}
 
-	return nil
+	return results
+}
+
+func findCustomResourcesByReference(customs []*unstructured.Unstructured, name, namespace string) []*unstructured.Unstructured {
+	var results []*unstructured.Unstructured
+	for _, custom := range customs {
+		if proto.MatchesNamePattern(name, custom.GetName()) && proto.MatchesNamePattern(namespace, custom.GetNamespace()) {
+			results = append(results, custom)
+		}
+	}
+
+	return results
+}
+
+// findSecretsOwnedBy returns Secrets with an owner reference to the custom resource, such
+// as the Secret of a cert-manager Certificate.
+func findSecretsOwnedBy(secrets []*corev1.Secret, custom *unstructured.Unstructured) []*corev1.Secret {
+	var results []*corev1.Secret
+	for _, secret := range secrets {
+		if secret.Namespace != custom.GetNamespace() {
+			continue
+		}
+
+		for _, owner := range secret.OwnerReferences {
+			if owner.UID == custom.GetUID() {
+				results = append(results, secret)
+				break
+			}
+		}
+	}
+
+	return results
 }
 
 // This is a representation for managed resources matched to a pod controller based on its
 // references in its pod template.
 type managedResourcesForPodController struct {
-	resources []*managedResource
+	controller podController
+	resources  []*managedResource
+
+	// keys of each managed resource consumed by the pod controller, by their UIDs
+	keys map[string]*proto.KeyReferences
+}
+
+// hasUID returns whether a resource with the UID is one of the managed resources
+// referenced by the pod controller.
+func (rs *managedResourcesForPodController) hasUID(uid string) bool {
+	for _, r := range rs.resources {
+		if r.getUID() == uid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasResource returns whether the changed resource is one of the managed resources
+// referenced by the pod controller.
+func (rs *managedResourcesForPodController) hasResource(c changedResource) bool {
+	for _, r := range rs.resources {
+		if r.matches(c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getChangedResources returns the managed resources referenced by the pod controller
+// which have changed since it was last restarted, according to the versions recorded on
+// it. If it has no record of them, only a changed resource taken from a work queue can
+// be identified, if given.
+func (rs *managedResourcesForPodController) getChangedResources(changed *changedResource) []*managedResource {
+	recorded, found := rs.controller.getRecordedVersions()
+
+	var resources []*managedResource
+	for _, r := range rs.resources {
+		if found {
+			if recorded[r.getDescription()] != r.getVersionHash(rs.keys[r.getUID()]) {
+				resources = append(resources, r)
+			}
+			continue
+		}
+
+		if changed != nil && r.matches(*changed) {
+			resources = append(resources, r)
+		}
+	}
+
+	return resources
+}
+
+// getVersions returns the versions of managed resources consumed by the pod controller,
+// encoded to be recorded in LabelManagedResourceVersions.
+func (rs *managedResourcesForPodController) getVersions() (string, error) {
+	versions := map[string]string{}
+	for _, r := range rs.resources {
+		versions[r.getDescription()] = r.getVersionHash(rs.keys[r.getUID()])
+	}
+
+	versionsBytes, err := json.Marshal(versions)
+	if err != nil {
+		return "", err
+	}
+
+	return string(versionsBytes), nil
+}
+
+// getRestartCooldown returns the restart cooldown applicable to the pod controller, which
+// is the shortest among the managed resources it references.
+func (rs *managedResourcesForPodController) getRestartCooldown() time.Duration {
+	cooldown := config.Config.XXXParsedRestartCooldown
+	overridden := false
+	for _, r := range rs.resources {
+		if r.config == nil {
+			continue
+		}
+
+		if !overridden || r.config.XXXParsedRestartCooldown < cooldown {
+			cooldown = r.config.XXXParsedRestartCooldown
+			overridden = true
+		}
+	}
+
+	return cooldown
+}
+
+// getPodControllerStagger returns the stagger to wait for after restarting the pod
+// controller, which is the longest among the managed resources it references.
+func (rs *managedResourcesForPodController) getPodControllerStagger() time.Duration {
+	stagger := config.Config.XXXParsedPodControllerStagger
+	overridden := false
+	for _, r := range rs.resources {
+		if r.config == nil {
+			continue
+		}
+
+		if !overridden || r.config.XXXParsedPodControllerStagger > stagger {
+			stagger = r.config.XXXParsedPodControllerStagger
+			overridden = true
+		}
+	}
+
+	return stagger
+}
+
+// getMaintenanceWindows returns the sets of maintenance windows applicable to restarts of
+// the pod controller, one for each managed resource it references, any window of each of
+// which must be open. Managed resources without windows of their own are subject to the
+// system-wide windows.
+func (rs *managedResourcesForPodController) getMaintenanceWindows() [][]*proto.MaintenanceWindow {
+	var windowSets [][]*proto.MaintenanceWindow
+	defaulted := false
+	for _, r := range rs.resources {
+		if r.config != nil && len(r.config.MaintenanceWindows) > 0 {
+			windowSets = append(windowSets, r.config.MaintenanceWindows)
+			continue
+		}
+
+		if !defaulted && len(config.Config.MaintenanceWindows) > 0 {
+			windowSets = append(windowSets, config.Config.MaintenanceWindows)
+			defaulted = true
+		}
+	}
+
+	return windowSets
+}
+
+// getBlackouts returns the blackouts applicable to restarts of the pod controller, which
+// are system-wide blackouts and those of all managed resources it references.
+func (rs *managedResourcesForPodController) getBlackouts() []*proto.Blackout {
+	var blackouts []*proto.Blackout
+	blackouts = append(blackouts, config.Config.Blackouts...)
+	for _, r := range rs.resources {
+		if r.config != nil {
+			blackouts = append(blackouts, r.config.Blackouts...)
+		}
+	}
+
+	return blackouts
+}
+
+// getJobPolicy returns what to do with the pod controller if it is a standalone Job, which
+// is to recreate it if any of the managed resources it references asks for it, even if
+// it has finished if any of them asks for that, and to otherwise only report it as out
+// of date.
+func (rs *managedResourcesForPodController) getJobPolicy() string {
+	policy := proto.JobPolicyNotifyOnly
+	for _, r := range rs.resources {
+		switch r.getJobPolicy() {
+		case proto.JobPolicyRecreateAll:
+			return proto.JobPolicyRecreateAll
+		case proto.JobPolicyRecreate:
+			policy = proto.JobPolicyRecreate
+		}
+	}
+
+	return policy
+}
+
+// recreatesJob returns whether the pod controller is a standalone Job to be re-created
+// under its job policy.
+func (rs *managedResourcesForPodController) recreatesJob() bool {
+	if rs.controller.job == nil {
+		return false
+	}
+
+	switch rs.getJobPolicy() {
+	case proto.JobPolicyRecreateAll:
+		return true
+	case proto.JobPolicyRecreate:
+		return !isJobFinished(rs.controller.job)
+	}
+
+	return false
+}
+
+// terminatesRunningJobs returns whether running Jobs spawned by the pod controller should
+// be terminated when it is restarted, which is the case if any of the managed resources
+// it references asks for it.
+func (rs *managedResourcesForPodController) terminatesRunningJobs() bool {
+	for _, r := range rs.resources {
+		if r.config != nil && r.config.TerminateRunningJobs {
+			return true
+		}
+	}
+
+	return false
 }
 
 // getHash returns a hash identifying the state of managed resources loaded by the target
 // pod controller at the time of last restart. To be used to identify managed resource
-// versions from the last rolling restart in order.kube-system.com/managed-resources-hash.
+// versions from the last rolling restart in order.kube-system.com/managed-resources-hash,
+// hence it is hex encoded to be safe as an annotation value.
 func (rs *managedResourcesForPodController) getHash() (string, error) {
-	// Maintain a stable order of resources based on their UIDs
-	resources := rs.resources
-	sort.SliceStable(resources, func(i, j int) bool { return resources[i].getUID() < resources[j].getUID() })
-
 	var resourceVersions []string
-	for _, r := range resources {
-		switch {
-		case r.secret != nil:
-			resourceVersions = append(resourceVersions, fmt.Sprintf("Secret:%v:%s", r.secret.GetUID(), r.secret.ResourceVersion))
-		case r.configMap != nil:
-			resourceVersions = append(resourceVersions, fmt.Sprintf("ConfigMap:%v:%s", r.configMap.GetUID(), r.configMap.ResourceVersion))
-		}
+	for _, r := range rs.resources {
+		resourceVersions = append(resourceVersions, r.getVersion(rs.keys[r.getUID()]))
 	}
 
+	// Maintain a stable order of resources, which is independent of their UIDs so that
+	// re-creating a resource with identical content does not change the hash.
+	sort.Strings(resourceVersions)
+
 	hasher := sha256.New()
 	_, err := hasher.Write([]byte(strings.Join(resourceVersions, "-")))
 	if err != nil {
 		return "", err
 	}
 
-	return hex.Dump(hasher.Sum(nil)), nil
+	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
@@ -0,0 +1,224 @@
+package processor
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/proto"
+)
+
+func testSecret(data map[string]string) *managedResource {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default", UID: "uid"},
+		Data:       map[string][]byte{},
+	}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+
+	return &managedResource{secret: secret}
+}
+
+func keyReferences(keys ...string) *proto.KeyReferences {
+	references := &proto.KeyReferences{}
+	for _, key := range keys {
+		references.AddKey(key)
+	}
+
+	return references
+}
+
+func TestManagedResourceGetVersion(t *testing.T) {
+	config.Config = &proto.OrderConfig{}
+
+	base := map[string]string{"username": "order", "password": "hunter2"}
+	tests := []struct {
+		name         string
+		keys         *proto.KeyReferences
+		changed      map[string]string
+		expectChange bool
+	}{
+		{"consumed key changed", keyReferences("password"), map[string]string{"username": "order", "password": "hunter3"}, true},
+		{"unconsumed key changed", keyReferences("password"), map[string]string{"username": "admin", "password": "hunter2"}, false},
+		{"unconsumed key added", keyReferences("password"), map[string]string{"username": "order", "password": "hunter2", "token": "abc"}, false},
+		{"consumed key removed", keyReferences("password"), map[string]string{"username": "order"}, true},
+		{"consumed key emptied", keyReferences("password"), map[string]string{"username": "order", "password": ""}, true},
+		{"missing consumed key added", keyReferences("token"), map[string]string{"username": "order", "password": "hunter2", "token": "abc"}, true},
+		{"whole resource with any key changed", proto.AllKeyReferences(), map[string]string{"username": "admin", "password": "hunter2"}, true},
+		{"whole resource with key added", proto.AllKeyReferences(), map[string]string{"username": "order", "password": "hunter2", "token": "abc"}, true},
+		{"no references consume the whole resource", nil, map[string]string{"username": "admin", "password": "hunter2"}, true},
+		{"nothing changed", keyReferences("username", "password"), base, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := testSecret(base).getVersion(test.keys)
+			after := testSecret(test.changed).getVersion(test.keys)
+			if changed := before != after; changed != test.expectChange {
+				t.Errorf("Expected version to change: %v, got %s before and %s after", test.expectChange, before, after)
+			}
+		})
+	}
+}
+
+func TestManagedResourceGetVersionKeyOrder(t *testing.T) {
+	config.Config = &proto.OrderConfig{}
+
+	r := testSecret(map[string]string{"a": "1", "b": "2"})
+	if r.getVersion(keyReferences("a", "b")) != r.getVersion(keyReferences("b", "a")) {
+		t.Errorf("Expected version not to depend on the order keys are referenced in")
+	}
+
+	// Values can't be shifted between adjacent keys without changing the version
+	shifted := testSecret(map[string]string{"a": "12", "b": ""})
+	if r.getVersion(keyReferences("a", "b")) == shifted.getVersion(keyReferences("a", "b")) {
+		t.Errorf("Expected version to change when content shifts between keys")
+	}
+}
+
+func managedResourceFixtures() []runtime.Object {
+	secret := func(namespace, name string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(namespace + "/" + name), Labels: labels}}
+	}
+	configMap := func(namespace, name string, labels map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(namespace + "/configmap/" + name), Labels: labels}}
+	}
+
+	return []runtime.Object{
+		secret("globs", "app-db", map[string]string{"tier": "backend"}),
+		secret("globs", "app-cache", map[string]string{"tier": "cache"}),
+		secret("globs", "web", map[string]string{"tier": "backend"}),
+		secret("globs-staging", "app-db", map[string]string{"tier": "backend"}),
+		configMap("globs", "app-settings", map[string]string{"tier": "backend"}),
+	}
+}
+
+func TestGetManagedResourcesInConfig(t *testing.T) {
+	selector := func(s string) labels.Selector {
+		parsed, err := labels.Parse(s)
+		if err != nil {
+			t.Fatalf("Error parsing selector %s: %v", s, err)
+		}
+		return parsed
+	}
+	secrets := func(name, namespace string) *proto.ManagedResource {
+		return &proto.ManagedResource{Type: proto.ManagedResourceTypeSecrets, Name: name, Namespace: namespace}
+	}
+	withSelector := func(r *proto.ManagedResource, s string) *proto.ManagedResource {
+		r.Selector = s
+		r.XXXParsedSelector = selector(s)
+		return r
+	}
+
+	tests := []struct {
+		name      string
+		resources []*proto.ManagedResource
+		expect    []string
+	}{
+		{"exact name", []*proto.ManagedResource{secrets("app-db", "globs")}, []string{"Secret globs/app-db"}},
+		{"glob name", []*proto.ManagedResource{secrets("app-*", "globs")}, []string{"Secret globs/app-cache", "Secret globs/app-db"}},
+		{"character class", []*proto.ManagedResource{secrets("app-[c]*", "globs")}, []string{"Secret globs/app-cache"}},
+		{"glob namespace", []*proto.ManagedResource{secrets("app-db", "globs*")}, []string{"Secret globs-staging/app-db", "Secret globs/app-db"}},
+		{"all namespaces", []*proto.ManagedResource{secrets("app-db", proto.AllNamespaces)}, []string{"Secret globs-staging/app-db", "Secret globs/app-db"}},
+		{"no match", []*proto.ManagedResource{secrets("api-*", "globs")}, nil},
+		{"type must match", []*proto.ManagedResource{{Type: proto.ManagedResourceTypeConfigMaps, Name: "app-*", Namespace: "globs"}}, []string{"ConfigMap globs/app-settings"}},
+		{"selector", []*proto.ManagedResource{withSelector(secrets("*", "globs"), "tier=backend")}, []string{"Secret globs/app-db", "Secret globs/web"}},
+		{"selector and glob name", []*proto.ManagedResource{withSelector(secrets("app-*", "globs"), "tier=backend")}, []string{"Secret globs/app-db"}},
+		{"set based selector", []*proto.ManagedResource{withSelector(secrets("*", "globs"), "tier in (cache)")}, []string{"Secret globs/app-cache"}},
+		{"selector matching nothing", []*proto.ManagedResource{withSelector(secrets("*", "globs"), "tier=frontend")}, nil},
+		{"overlapping entries match once", []*proto.ManagedResource{secrets("app-db", "globs"), secrets("app-*", "globs")}, []string{"Secret globs/app-cache", "Secret globs/app-db"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Config = &proto.OrderConfig{ManagedResources: test.resources}
+
+			resources, err := getManagedResourcesInConfig()
+			if err != nil {
+				t.Fatalf("Error getting managed resources: %v", err)
+			}
+
+			var descriptions []string
+			for _, r := range resources {
+				descriptions = append(descriptions, r.getDescription())
+			}
+			sort.Strings(descriptions)
+
+			if !reflect.DeepEqual(descriptions, test.expect) {
+				t.Errorf("Expected managed resources %v, got %v", test.expect, descriptions)
+			}
+		})
+	}
+}
+
+func TestGetManagedResourcesInConfigFirstEntryApplies(t *testing.T) {
+	exact := &proto.ManagedResource{Type: proto.ManagedResourceTypeSecrets, Name: "app-db", Namespace: "globs"}
+	glob := &proto.ManagedResource{Type: proto.ManagedResourceTypeSecrets, Name: "app-*", Namespace: "globs"}
+	config.Config = &proto.OrderConfig{ManagedResources: []*proto.ManagedResource{exact, glob}}
+
+	resources, err := getManagedResourcesInConfig()
+	if err != nil {
+		t.Fatalf("Error getting managed resources: %v", err)
+	}
+
+	for _, r := range resources {
+		expected := glob
+		if r.getDescription() == "Secret globs/app-db" {
+			expected = exact
+		}
+		if r.config != expected {
+			t.Errorf("Expected %s to be managed under %s, got %s", r.getDescription(), expected.Name, r.config.Name)
+		}
+	}
+}
+
+func TestManagedResourcePermits(t *testing.T) {
+	deployment := func(namespace, name string) podController {
+		return podController{deployment: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}}
+	}
+	reference := func(controllerType, namespace, name string) *proto.PodControllerReference {
+		return &proto.PodControllerReference{Type: controllerType, Namespace: namespace, Name: name}
+	}
+	resource := func(whitelisted, blacklisted []*proto.PodControllerReference) *managedResource {
+		r := testSecret(nil)
+		r.config = &proto.ManagedResource{WhitelistedControllers: whitelisted, BlacklistedControllers: blacklisted}
+		return r
+	}
+	web := []*proto.PodControllerReference{reference(proto.PodControllerTypeDeployments, "", "web")}
+
+	tests := []struct {
+		name       string
+		resource   *managedResource
+		controller podController
+		expect     bool
+	}{
+		{"not in config", testSecret(nil), deployment("default", "web"), true},
+		{"no whitelisted or blacklisted controllers", resource(nil, nil), deployment("default", "web"), true},
+		{"whitelisted", resource(web, nil), deployment("default", "web"), true},
+		{"not whitelisted", resource(web, nil), deployment("default", "api"), false},
+		{"whitelisted in another namespace", resource(web, nil), deployment("other", "web"), false},
+		{"whitelisted with namespace", resource([]*proto.PodControllerReference{reference(proto.PodControllerTypeDeployments, "other", "web")}, nil), deployment("other", "web"), true},
+		{"whitelisted as another type", resource([]*proto.PodControllerReference{reference(proto.PodControllerTypeStatefulSets, "", "web")}, nil), deployment("default", "web"), false},
+		{"blacklisted", resource(nil, web), deployment("default", "web"), false},
+		{"not blacklisted", resource(nil, web), deployment("default", "api"), true},
+		{"whitelist takes precedence over blacklist", resource(web, web), deployment("default", "web"), true},
+		{"blacklist ignored if whitelist is set", resource(web, []*proto.PodControllerReference{reference(proto.PodControllerTypeDeployments, "", "api")}), deployment("default", "api"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if permits := test.resource.permits(test.controller); permits != test.expect {
+				t.Errorf("Expected %s to be permitted: %v, got %v", test.controller.getKey(), test.expect, permits)
+			}
+		})
+	}
+}
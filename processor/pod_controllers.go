@@ -0,0 +1,483 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/chongyangshi/Order/controllers/cachers"
+	"github.com/chongyangshi/Order/controllers/configmaps"
+	"github.com/chongyangshi/Order/controllers/secrets"
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// podController represents a controller whose pods may mount or reference resources
+// managed by Order, and which can be rolling restarted by updating its pod template.
+// The underlying supported types may be subject to future extension. Currently
+// DaemonSet, Deployment, StatefulSet, standalone ReplicaSet, ReplicationController and
+// bare Pod are supported. ReplicaSets and ReplicationControllers don't replace their
+// pods when their pod templates change, so Order replaces their pods gradually itself.
+// Bare Pods are restarted by deleting them, if they have opted into it. CronJobs are
+// not restarted as such, but have their Job templates updated so that future Jobs
+// pick up changes. Standalone Jobs can't be restarted either, and are handled according
+// to the job policy of managed resources they reference. Custom resources embedding a
+// pod template, such as Argo Rollouts, are supported if configured in
+// custom_pod_controllers, and are expected to roll out their pods themselves.
+type podController struct {
+	daemonSet             *appsv1.DaemonSet
+	deployment            *appsv1.Deployment
+	statefulSet           *appsv1.StatefulSet
+	replicaSet            *appsv1.ReplicaSet
+	replicationController *corev1.ReplicationController
+	pod                   *corev1.Pod
+	cronJob               *batchv1.CronJob
+	job                   *batchv1.Job
+	custom                *cachers.CustomPodController
+}
+
+// getPodControllers returns all pod controllers currently in cache matching target
+// namespaces, which can be restarted by Order.
+func getPodControllers() ([]podController, error) {
+	var podControllers []podController
+
+	// Retrieve DaemonSets currently in cache matching target namespaces
+	daemonSets, err := cachers.GetDaemonSets()
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range daemonSets {
+		podControllers = append(podControllers, podController{daemonSet: ds})
+	}
+
+	// Retrieve Deployments currently in cache matching target namespaces
+	deployments, err := cachers.GetDeployments()
+	if err != nil {
+		return nil, err
+	}
+	for _, deploy := range deployments {
+		podControllers = append(podControllers, podController{deployment: deploy})
+	}
+
+	// Retrieve StatefulSets currently in cache matching target namespaces
+	statefulSets, err := cachers.GetStatefulSets()
+	if err != nil {
+		return nil, err
+	}
+	for _, sts := range statefulSets {
+		podControllers = append(podControllers, podController{statefulSet: sts})
+	}
+
+	// Retrieve standalone ReplicaSets currently in cache matching target namespaces
+	replicaSets, err := cachers.GetReplicaSets()
+	if err != nil {
+		return nil, err
+	}
+	for _, rs := range replicaSets {
+		podControllers = append(podControllers, podController{replicaSet: rs})
+	}
+
+	// Retrieve ReplicationControllers currently in cache matching target namespaces
+	replicationControllers, err := cachers.GetReplicationControllers()
+	if err != nil {
+		return nil, err
+	}
+	for _, rc := range replicationControllers {
+		if rc.Spec.Template == nil {
+			continue
+		}
+		podControllers = append(podControllers, podController{replicationController: rc})
+	}
+
+	// Retrieve bare Pods currently in cache matching target namespaces, which have
+	// opted into being restarted by deletion, as nothing will re-create them.
+	pods, err := cachers.GetBarePods()
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		c := podController{pod: pod}
+		if value, _ := c.getAnnotation(proto.LabelRestartByDeletion); value != "true" {
+			continue
+		}
+		podControllers = append(podControllers, c)
+	}
+
+	// Retrieve CronJobs currently in cache matching target namespaces
+	cronJobs, err := cachers.GetCronJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, cronJob := range cronJobs {
+		podControllers = append(podControllers, podController{cronJob: cronJob})
+	}
+
+	// Retrieve standalone Jobs currently in cache matching target namespaces. Jobs
+	// spawned by CronJobs are handled through their CronJobs.
+	jobs, err := cachers.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if metav1.GetControllerOf(job) != nil {
+			continue
+		}
+		podControllers = append(podControllers, podController{job: job})
+	}
+
+	// Retrieve custom pod controllers currently in cache matching target namespaces
+	customs, err := cachers.GetCustomPodControllers()
+	if err != nil {
+		return nil, err
+	}
+	for _, custom := range customs {
+		podControllers = append(podControllers, podController{custom: custom})
+	}
+
+	return podControllers, nil
+}
+
+func (c podController) getKind() string {
+	switch {
+	case c.daemonSet != nil:
+		return proto.PodControllerTypeDaemonSets
+	case c.deployment != nil:
+		return proto.PodControllerTypeDeployments
+	case c.statefulSet != nil:
+		return proto.PodControllerTypeStatefulSets
+	case c.replicaSet != nil:
+		return proto.PodControllerTypeReplicaSets
+	case c.replicationController != nil:
+		return proto.PodControllerTypeReplicationControllers
+	case c.pod != nil:
+		return proto.PodControllerTypePods
+	case c.cronJob != nil:
+		return proto.PodControllerTypeCronJobs
+	case c.job != nil:
+		return proto.PodControllerTypeJobs
+	case c.custom != nil:
+		return c.custom.Kind.Kind
+	}
+
+	return ""
+}
+
+func (c podController) getObjectMeta() metav1.Object {
+	switch {
+	case c.daemonSet != nil:
+		return &c.daemonSet.ObjectMeta
+	case c.deployment != nil:
+		return &c.deployment.ObjectMeta
+	case c.statefulSet != nil:
+		return &c.statefulSet.ObjectMeta
+	case c.replicaSet != nil:
+		return &c.replicaSet.ObjectMeta
+	case c.replicationController != nil:
+		return &c.replicationController.ObjectMeta
+	case c.pod != nil:
+		return &c.pod.ObjectMeta
+	case c.cronJob != nil:
+		return &c.cronJob.ObjectMeta
+	case c.job != nil:
+		return &c.job.ObjectMeta
+	case c.custom != nil:
+		return c.custom.Object
+	}
+
+	return &metav1.ObjectMeta{}
+}
+
+// getObject returns the underlying object of the pod controller, such as for recording
+// Events on it.
+func (c podController) getObject() runtime.Object {
+	switch {
+	case c.daemonSet != nil:
+		return c.daemonSet
+	case c.deployment != nil:
+		return c.deployment
+	case c.statefulSet != nil:
+		return c.statefulSet
+	case c.replicaSet != nil:
+		return c.replicaSet
+	case c.replicationController != nil:
+		return c.replicationController
+	case c.pod != nil:
+		return c.pod
+	case c.cronJob != nil:
+		return c.cronJob
+	case c.job != nil:
+		return c.job
+	case c.custom != nil:
+		return c.custom.Object
+	}
+
+	return nil
+}
+
+func (c podController) getPodSpec() corev1.PodSpec {
+	switch {
+	case c.daemonSet != nil:
+		return c.daemonSet.Spec.Template.Spec
+	case c.deployment != nil:
+		return c.deployment.Spec.Template.Spec
+	case c.statefulSet != nil:
+		return c.statefulSet.Spec.Template.Spec
+	case c.replicaSet != nil:
+		return c.replicaSet.Spec.Template.Spec
+	case c.replicationController != nil && c.replicationController.Spec.Template != nil:
+		return c.replicationController.Spec.Template.Spec
+	case c.pod != nil:
+		return c.pod.Spec
+	case c.cronJob != nil:
+		return c.cronJob.Spec.JobTemplate.Spec.Template.Spec
+	case c.job != nil:
+		return c.job.Spec.Template.Spec
+	case c.custom != nil:
+		return c.custom.PodTemplate.Spec
+	}
+
+	return corev1.PodSpec{}
+}
+
+// getPodTemplateAnnotations returns annotations on the pod template of the pod controller,
+// which become annotations of its pods. For a bare Pod, these are its own annotations.
+func (c podController) getPodTemplateAnnotations() map[string]string {
+	switch {
+	case c.daemonSet != nil:
+		return c.daemonSet.Spec.Template.Annotations
+	case c.deployment != nil:
+		return c.deployment.Spec.Template.Annotations
+	case c.statefulSet != nil:
+		return c.statefulSet.Spec.Template.Annotations
+	case c.replicaSet != nil:
+		return c.replicaSet.Spec.Template.Annotations
+	case c.replicationController != nil && c.replicationController.Spec.Template != nil:
+		return c.replicationController.Spec.Template.Annotations
+	case c.pod != nil:
+		return c.pod.Annotations
+	case c.cronJob != nil:
+		return c.cronJob.Spec.JobTemplate.Spec.Template.Annotations
+	case c.job != nil:
+		return c.job.Spec.Template.Annotations
+	case c.custom != nil:
+		return c.custom.PodTemplate.Annotations
+	}
+
+	return nil
+}
+
+// getPodTemplateLabels returns labels on the pod template of the pod controller, which
+// become labels of its pods. For a bare Pod, these are its own labels.
+func (c podController) getPodTemplateLabels() map[string]string {
+	switch {
+	case c.daemonSet != nil:
+		return c.daemonSet.Spec.Template.Labels
+	case c.deployment != nil:
+		return c.deployment.Spec.Template.Labels
+	case c.statefulSet != nil:
+		return c.statefulSet.Spec.Template.Labels
+	case c.replicaSet != nil:
+		return c.replicaSet.Spec.Template.Labels
+	case c.replicationController != nil && c.replicationController.Spec.Template != nil:
+		return c.replicationController.Spec.Template.Labels
+	case c.pod != nil:
+		return c.pod.Labels
+	case c.cronJob != nil:
+		return c.cronJob.Spec.JobTemplate.Spec.Template.Labels
+	case c.job != nil:
+		return c.job.Spec.Template.Labels
+	case c.custom != nil:
+		return c.custom.PodTemplate.Labels
+	}
+
+	return nil
+}
+
+// disruptsPods returns whether restarting the pod controller would replace pods currently
+// running. This is not the case for CronJobs, whose Job templates are only updated for
+// future Jobs, for Jobs without active pods, or for pod controllers scaled to zero.
+func (c podController) disruptsPods() bool {
+	switch {
+	case c.daemonSet != nil:
+		return c.daemonSet.Status.CurrentNumberScheduled > 0
+	case c.deployment != nil:
+		return replicasOrDefault(c.deployment.Spec.Replicas) > 0
+	case c.statefulSet != nil:
+		return replicasOrDefault(c.statefulSet.Spec.Replicas) > 0
+	case c.replicaSet != nil:
+		return replicasOrDefault(c.replicaSet.Spec.Replicas) > 0
+	case c.replicationController != nil:
+		return replicasOrDefault(c.replicationController.Spec.Replicas) > 0
+	case c.cronJob != nil:
+		return false
+	case c.job != nil:
+		return c.job.Status.Active > 0
+	}
+
+	// Bare Pods are deleted, and custom pod controllers roll out their pods
+	return true
+}
+
+// getBlockingDisruptionBudget returns a PodDisruptionBudget selecting pods of the pod
+// controller which currently allows no disruptions, if any. Restarting the pod controller
+// would then disrupt a workload which is already degraded. Pod controllers whose restarts
+// don't replace running pods are never blocked.
+func (c podController) getBlockingDisruptionBudget() *policyv1.PodDisruptionBudget {
+	if !c.disruptsPods() {
+		return nil
+	}
+
+	pdbs, err := cachers.GetPodDisruptionBudgetsSelecting(c.getObjectMeta().GetNamespace(), labels.Set(c.getPodTemplateLabels()))
+	if err != nil {
+		logging.Log("Error looking up PodDisruptionBudgets of %s: %v", c.getKey(), err)
+		return nil
+	}
+
+	for _, pdb := range pdbs {
+		if pdb.Status.DisruptionsAllowed <= 0 {
+			return pdb
+		}
+	}
+
+	return nil
+}
+
+// getKey returns a string uniquely identifying the pod controller in the cluster.
+func (c podController) getKey() string {
+	meta := c.getObjectMeta()
+	return fmt.Sprintf("%s/%s/%s", c.getKind(), meta.GetNamespace(), meta.GetName())
+}
+
+// getAnnotation returns the value of an annotation managed by Order on the pod
+// controller, and whether it is set at all.
+func (c podController) getAnnotation(label string) (string, bool) {
+	value, found := c.getObjectMeta().GetAnnotations()[annotationKey(label)]
+	return value, found
+}
+
+// getRecordedHash returns the managed resources hash recorded on the pod controller when
+// it was last restarted or adopted, and whether it was recorded. In dry run, what we
+// would have recorded takes precedence.
+func (c podController) getRecordedHash() (string, bool) {
+	if isDryRun() {
+		if record, recorded := getDryRunRecord(c); recorded {
+			return record.hash, true
+		}
+	}
+
+	return c.getAnnotation(proto.LabelManagedResourcesHash)
+}
+
+// getRecordedVersions returns versions of managed resources recorded on the pod controller
+// when it was last restarted, by their descriptions, and whether they were recorded. In
+// dry run, what we would have recorded takes precedence.
+func (c podController) getRecordedVersions() (map[string]string, bool) {
+	value, found := c.getAnnotation(proto.LabelManagedResourceVersions)
+	if isDryRun() {
+		if record, recorded := getDryRunRecord(c); recorded {
+			value, found = record.versions, true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	versions := map[string]string{}
+	err := json.Unmarshal([]byte(value), &versions)
+	if err != nil {
+		logging.Debug("Ignoring invalid %s annotation on %s: %v", annotationKey(proto.LabelManagedResourceVersions), c.getKey(), err)
+		return nil, false
+	}
+
+	return versions, true
+}
+
+// hasReference returns whether the pod controller's pod template references the
+// managed resource.
+func (c podController) hasReference(r *managedResource) bool {
+	switch {
+	case r.secret != nil:
+		switch {
+		case c.daemonSet != nil:
+			return secrets.DaemonSetHasReference(c.daemonSet, r.secret)
+		case c.deployment != nil:
+			return secrets.DeploymentHasReference(c.deployment, r.secret)
+		case c.statefulSet != nil:
+			return secrets.StatefulSetHasReference(c.statefulSet, r.secret)
+		case c.replicaSet != nil:
+			return secrets.ReplicaSetHasReference(c.replicaSet, r.secret)
+		case c.replicationController != nil:
+			return secrets.ReplicationControllerHasReference(c.replicationController, r.secret)
+		case c.pod != nil:
+			return secrets.PodHasReference(c.pod, r.secret)
+		case c.cronJob != nil:
+			return secrets.CronJobHasReference(c.cronJob, r.secret)
+		case c.job != nil:
+			return secrets.JobHasReference(c.job, r.secret)
+		case c.custom != nil:
+			return secrets.PodTemplateHasReference(c.custom.Object, &c.custom.PodTemplate, r.secret)
+		}
+
+	case r.configMap != nil:
+		switch {
+		case c.daemonSet != nil:
+			return configmaps.DaemonSetHasReference(c.daemonSet, r.configMap)
+		case c.deployment != nil:
+			return configmaps.DeploymentHasReference(c.deployment, r.configMap)
+		case c.statefulSet != nil:
+			return configmaps.StatefulSetHasReference(c.statefulSet, r.configMap)
+		case c.replicaSet != nil:
+			return configmaps.ReplicaSetHasReference(c.replicaSet, r.configMap)
+		case c.replicationController != nil:
+			return configmaps.ReplicationControllerHasReference(c.replicationController, r.configMap)
+		case c.pod != nil:
+			return configmaps.PodHasReference(c.pod, r.configMap)
+		case c.cronJob != nil:
+			return configmaps.CronJobHasReference(c.cronJob, r.configMap)
+		case c.job != nil:
+			return configmaps.JobHasReference(c.job, r.configMap)
+		case c.custom != nil:
+			return configmaps.PodTemplateHasReference(c.custom.Object, &c.custom.PodTemplate, r.configMap)
+		}
+	}
+
+	return false
+}
+
+// getKeyReferences returns keys of the managed resource consumed by the pod controller's
+// pod template, or nil if it does not reference the managed resource at all.
+func (c podController) getKeyReferences(r *managedResource) *proto.KeyReferences {
+	// Custom resources can't be referenced by pod templates, but are linked to them
+	// instead, and are consumed as a whole.
+	if r.custom != nil {
+		if r.linksTo(c) {
+			return proto.AllKeyReferences()
+		}
+		return nil
+	}
+
+	if !c.hasReference(r) {
+		return nil
+	}
+
+	switch {
+	case r.secret != nil:
+		return secrets.PodSpecKeyReferences(c.getPodSpec(), r.secret.Name)
+	case r.configMap != nil:
+		return configmaps.PodSpecKeyReferences(c.getPodSpec(), r.configMap.Name)
+	}
+
+	return nil
+}
+
+// annotationKey returns the fully qualified key of an annotation managed by Order.
+func annotationKey(label string) string {
+	return fmt.Sprintf("%s/%s", proto.LabelPrefix, label)
+}
@@ -0,0 +1,132 @@
+package processor
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/controllers/cachers"
+	"github.com/chongyangshi/Order/proto"
+)
+
+func testDeployment(namespace string, replicas *int32, labels map[string]string) podController {
+	return podController{deployment: &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "web"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: replicas,
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+	}}
+}
+
+func TestDisruptsPods(t *testing.T) {
+	template := corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}}}
+
+	tests := []struct {
+		name       string
+		controller podController
+		expect     bool
+	}{
+		{"Deployment", testDeployment("default", int32Ptr(3), nil), true},
+		{"Deployment with default replicas", testDeployment("default", nil, nil), true},
+		{"Deployment scaled to zero", testDeployment("default", int32Ptr(0), nil), false},
+		{"StatefulSet", podController{statefulSet: &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: int32Ptr(1)}}}, true},
+		{"StatefulSet scaled to zero", podController{statefulSet: &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: int32Ptr(0)}}}, false},
+		{"ReplicaSet scaled to zero", podController{replicaSet: &appsv1.ReplicaSet{Spec: appsv1.ReplicaSetSpec{Replicas: int32Ptr(0)}}}, false},
+		{"ReplicationController", podController{replicationController: &corev1.ReplicationController{Spec: corev1.ReplicationControllerSpec{Replicas: int32Ptr(2)}}}, true},
+		{"DaemonSet with pods scheduled", podController{daemonSet: &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{CurrentNumberScheduled: 2}}}, true},
+		{"DaemonSet without pods scheduled", podController{daemonSet: &appsv1.DaemonSet{}}, false},
+		{"CronJob", podController{cronJob: &batchv1.CronJob{Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: template}}}}}, false},
+		{"Job with active pods", podController{job: &batchv1.Job{Status: batchv1.JobStatus{Active: 1}}}, true},
+		{"Job without active pods", podController{job: &batchv1.Job{Status: batchv1.JobStatus{Succeeded: 1}}}, false},
+		{"bare Pod", podController{pod: &corev1.Pod{}}, true},
+		{"custom pod controller", podController{custom: &cachers.CustomPodController{Kind: &proto.CustomPodController{Kind: "Rollout"}}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if disrupts := test.controller.disruptsPods(); disrupts != test.expect {
+				t.Errorf("Expected restart to disrupt pods: %v, got %v", test.expect, disrupts)
+			}
+		})
+	}
+}
+
+func disruptionBudgetFixtures() []runtime.Object {
+	pdb := func(namespace, name string, selector *metav1.LabelSelector, disruptionsAllowed int32) *policyv1.PodDisruptionBudget {
+		return &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+		}
+	}
+	matchLabels := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+	}
+
+	return []runtime.Object{
+		pdb("budgets", "web", matchLabels("web"), 0),
+		pdb("budgets", "api", matchLabels("api"), 1),
+		pdb("budgets", "worker", &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"worker", "batch"}}},
+		}, 0),
+		pdb("budgets", "unselective", nil, 0),
+		pdb("budgets-all", "everything", &metav1.LabelSelector{}, 0),
+	}
+}
+
+func TestGetBlockingDisruptionBudget(t *testing.T) {
+	config.Config = &proto.OrderConfig{}
+
+	web := map[string]string{"app": "web"}
+	jobWithPods := func(namespace string, active int32) podController {
+		return podController{job: &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "migrate"},
+			Spec:       batchv1.JobSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: web}}},
+			Status:     batchv1.JobStatus{Active: active},
+		}}
+	}
+
+	tests := []struct {
+		name       string
+		controller podController
+		expect     string
+	}{
+		{"budget allowing no disruptions", testDeployment("budgets", int32Ptr(2), web), "web"},
+		{"budget allowing disruptions", testDeployment("budgets", int32Ptr(2), map[string]string{"app": "api"}), ""},
+		{"selector expression", testDeployment("budgets", int32Ptr(2), map[string]string{"app": "batch"}), "worker"},
+		{"no budget selecting pods", testDeployment("budgets", int32Ptr(2), map[string]string{"app": "cache"}), ""},
+		{"budget without selector selects nothing", testDeployment("budgets", int32Ptr(2), nil), ""},
+		{"budget with empty selector selects everything", testDeployment("budgets-all", int32Ptr(2), map[string]string{"app": "cache"}), "everything"},
+		{"budget in another namespace", testDeployment("default", int32Ptr(2), web), ""},
+		{"scaled to zero", testDeployment("budgets", int32Ptr(0), web), ""},
+		{"Job with active pods", jobWithPods("budgets", 1), "web"},
+		{"Job without active pods", jobWithPods("budgets", 0), ""},
+		{"bare Pod", podController{pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "budgets", Name: "web", Labels: web}}}, "web"},
+		{"CronJob", podController{cronJob: &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "budgets", Name: "report"},
+			Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: web}},
+			}}},
+		}}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := ""
+			if pdb := test.controller.getBlockingDisruptionBudget(); pdb != nil {
+				name = pdb.Name
+			}
+
+			if name != test.expect {
+				t.Errorf("Expected blocking PodDisruptionBudget %q, got %q", test.expect, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/chongyangshi/Order/controllers/cachers"
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// replacePodsGradually replaces pods of a ReplicaSet or ReplicationController which
+// predate its last rolling restart by Order, as unlike other pod controllers, they
+// don't replace their pods when their pod templates change. To keep the restart
+// rolling, at most one pod is deleted in each call, and only when all of the pod
+// controller's pods are running and ready, and PodDisruptionBudgets selecting them allow
+// disruptions.
+func replacePodsGradually(ctx context.Context, c podController) error {
+	var desiredReplicas int32
+	switch {
+	case c.replicaSet != nil:
+		desiredReplicas = replicasOrDefault(c.replicaSet.Spec.Replicas)
+	case c.replicationController != nil:
+		desiredReplicas = replicasOrDefault(c.replicationController.Spec.Replicas)
+	default:
+		return nil
+	}
+
+	restartedAt, found := c.getPodTemplateAnnotations()[annotationKey(proto.LabelLastRollingRestart)]
+	if !found {
+		return nil
+	}
+
+	meta := c.getObjectMeta()
+	pods, err := cachers.GetPodsControlledBy(meta.GetNamespace(), meta.GetUID())
+	if err != nil {
+		return err
+	}
+
+	if int32(len(pods)) < desiredReplicas {
+		logging.Debug("Waiting for %s to create all its pods before replacing any more", c.getKey())
+		return nil
+	}
+
+	var outdated []*corev1.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || !isPodReady(pod) {
+			logging.Debug("Waiting for all pods of %s to be ready before replacing any more", c.getKey())
+			return nil
+		}
+
+		if pod.Annotations[annotationKey(proto.LabelLastRollingRestart)] != restartedAt {
+			outdated = append(outdated, pod)
+		}
+	}
+
+	if len(outdated) == 0 {
+		return nil
+	}
+
+	// Deleting pods is a disruption like any other restart
+	if pdb := c.getBlockingDisruptionBudget(); pdb != nil {
+		logging.Debug("Waiting for PodDisruptionBudget %s/%s to allow disruptions before replacing any more pods of %s", pdb.Namespace, pdb.Name, c.getKey())
+		return nil
+	}
+
+	sort.Slice(outdated, func(i, j int) bool { return outdated[i].Name < outdated[j].Name })
+	pod := outdated[0]
+
+	if isDryRun() {
+		logging.Log("Dry run: would replace pod %s of %s, %d pods remain to be replaced", pod.Name, c.getKey(), len(outdated)-1)
+		return nil
+	}
+
+	logging.Log("Replacing pod %s of %s, %d pods remain to be replaced", pod.Name, c.getKey(), len(outdated)-1)
+	uid := pod.UID
+	return clientSet.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	})
+}
+
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+
+	return *replicas
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
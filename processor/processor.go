@@ -2,51 +2,103 @@ package processor
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/chongyangshi/Order/config"
 	"github.com/chongyangshi/Order/controllers/cachers"
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
+	"github.com/chongyangshi/Order/proto"
 )
 
-// @TODO: Control loop
-// - Pop from buffer
-// - Check whether resource is managed
-// - If resource is not managed, discard
-// - If resource is managed, continue
-// - Push back into buffer if minimum cooldown not yet met
-// - Look up currently matching pod controllers from cachers
-// - For each matching pod controller, if namespace does not qualify, skip
-// - If namespace qualifies, check whether managed resource hash annotations match
-// - If matches, skip as pod controller is already up to date
-// - If does not match, check if minimum cooldown from last restart qualifies
-// - If minimum cooldown does not qualifies, push back into buffer and skip
-// - If minimum cooldown qualifies, perform rolling restart and continue.
+var (
+	// clientSet is used by the processor to patch pod controllers requiring restarts.
+	clientSet kubernetes.Interface
+
+	// dynamicClient is used by the processor to patch custom pod controllers.
+	dynamicClient dynamic.Interface
+
+	// reconcileLock serialises reconciliations from the periodic control loop and from
+	// work queues, so that they don't race to restart the same pod controllers.
+	reconcileLock sync.Mutex
+
+	// knownResources are managed resources found in config, or consumed by pod
+	// controllers, when we last reconciled, as identified when taken from work queues.
+	// Changes in other resources taken from work queues are skipped, leaving anything
+	// new since to the next control loop. It is nil until we first reconcile, and is
+	// guarded by reconcileLock.
+	knownResources map[changedResource]bool
+)
+
+// Init sets up the processor, it should be called after controllers have synced and
+// before the processor is run.
+func Init(c kubernetes.Interface, d dynamic.Interface) {
+	clientSet = c
+	dynamicClient = d
+	recorder = newEventRecorder(c)
+}
+
+// isDryRun returns whether the processor should only report what it would do, without
+// making any changes in the cluster.
+func isDryRun() bool {
+	return config.Config != nil && config.Config.DryRun
+}
 
 // In a control loop, we validate all pod controllers against the versions of managed
 // resources they run. It is unnecessary to use locking and keep caches in a consistent
 // state while we process them, as it will simply be covered in the next loop under
 // an eventually consistent model.
+//
+// For each managed resource, we look up pod controllers currently in cache which
+// reference it. For each matched pod controller, we compare the hash of the managed
+// resources it references with the hash recorded on it at its last restart. If they
+// don't match and the restart cooldown since its last restart has passed, we queue a
+// rolling restart on it; otherwise it will be checked again in the next loop. Queued
+// restarts are performed at least a pod controller stagger apart.
 func controlLoop(ctx context.Context) error {
-	// Retrieve DaemonSets currently in cache matching target namespaces
-	daemonSets, err := cachers.GetDaemonSets()
-	if err != nil {
-		return err
-	}
+	return reconcile(ctx, nil)
+}
 
-	// Retrieve Deployments currently in cache matching target namespaces
-	deployments, err := cachers.GetDeployments()
-	if err != nil {
-		return err
+// reconcile matches managed resources to pod controllers currently in cache, and
+// restarts pod controllers requiring it. If changed is set, only pod controllers
+// referencing the changed managed resource are reconciled.
+func reconcile(ctx context.Context, changed *changedResource) error {
+	reconcileLock.Lock()
+	defer reconcileLock.Unlock()
+
+	// Most changes taken from work queues are in resources not managed by Order, which
+	// we don't need to match against every pod controller.
+	if changed != nil && knownResources != nil && !knownResources[*changed] {
+		logging.Debug("Skipping changed %s %s/%s, as it is not a managed resource", changed.resourceType, changed.namespace, changed.name)
+		return nil
 	}
 
-	// Retrieve jobs currently in cache matching target namespaces
-	jobs, err := cachers.GetJobs()
+	pass := restarts.nextPass()
+
+	podControllers, err := getPodControllers()
 	if err != nil {
 		return err
 	}
 
-	// Retrieve StatefulSets currently in cache matching target namespaces
-	statefulSets, err := cachers.GetStatefulSets()
-	if err != nil {
-		return err
+	// Account for restarts previously performed on pod controllers, which may have been
+	// performed before the processor last started, in staggering restarts.
+	for _, controller := range podControllers {
+		value, found := controller.getAnnotation(proto.LabelLastRollingRestart)
+		if !found {
+			continue
+		}
+
+		lastRestart, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			continue
+		}
+		restarts.observeRestart(lastRestart)
 	}
 
 	// Retrieve state of managed resources
@@ -55,9 +107,178 @@ func controlLoop(ctx context.Context) error {
 		return err
 	}
 
+	// Explain why a changed managed resource won't restart anything, if it lives in a
+	// namespace where Order doesn't act on pod controllers.
+	for i := range managedResources {
+		resource := &managedResources[i]
+		if changed != nil && resource.matches(*changed) && !cachers.InConfigNamespaces(resource.getNamespace()) {
+			recordEvent(resource.getObject(), corev1.EventTypeWarning, eventReasonRestartBlocked, "Change does not restart pod controllers, as namespace %s is not in namespaces set in config", resource.getNamespace())
+		}
+	}
+
 	// For each managed resource, compute what pod controllers currently in cache
 	// they apply to.
-	for _, resource := range managedResources {
+	matched := map[string]*managedResourcesForPodController{}
+	var matchedKeys []string
+	addMatch := func(controller podController, resource *managedResource, keys *proto.KeyReferences) {
+		// Jobs are only managed if the managed resource's job policy asks for it.
+		if controller.job != nil && resource.getJobPolicy() == proto.JobPolicyIgnore {
+			return
+		}
+
+		key := controller.getKey()
+		if _, found := matched[key]; !found {
+			matched[key] = &managedResourcesForPodController{
+				controller: controller,
+				keys:       map[string]*proto.KeyReferences{},
+			}
+			matchedKeys = append(matchedKeys, key)
+		}
+
+		// A resource may be both managed in config and watched in annotation, in which
+		// case config applies.
+		if matched[key].hasUID(resource.getUID()) {
+			return
+		}
+		matched[key].resources = append(matched[key].resources, resource)
+		matched[key].keys[resource.getUID()] = keys
+	}
+
+	// Managed resources in config, keyed by UID. As config applies to resources also
+	// watched in annotation or auto discovered, their whitelisted and blacklisted
+	// controllers must permit pod controllers matched through those means too.
+	configured := map[string][]*managedResource{}
+	for i := range managedResources {
+		resource := &managedResources[i]
+		configured[resource.getUID()] = append(configured[resource.getUID()], resource)
+	}
+	permitted := func(controller podController, resource *managedResource) bool {
+		if !resource.permits(controller) {
+			return false
+		}
+
+		for _, configuredResource := range configured[resource.getUID()] {
+			if !configuredResource.permits(controller) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for i := range managedResources {
+		resource := &managedResources[i]
+		for _, controller := range podControllers {
+			keys := controller.getKeyReferences(resource)
+			if keys == nil {
+				continue
+			}
+
+			if !resource.permits(controller) {
+				logging.Debug("%s references managed %s, but is not permitted to be restarted by its whitelisted or blacklisted controllers", controller.getKey(), resource.getDescription())
+				// Only count exclusions when the managed resource has changed, rather than
+				// in every control loop.
+				if changed != nil && resource.matches(*changed) {
+					metrics.RestartSkipped(controller.getObjectMeta().GetNamespace(), controller.getKind(), metrics.SkipReasonBlacklist)
+					recordEvent(controller.getObject(), corev1.EventTypeWarning, eventReasonRestartBlocked, "Not restarted due to change in %s, as it is excluded by whitelisted or blacklisted controllers", resource.getDescription())
+					recordEvent(resource.getObject(), corev1.EventTypeWarning, eventReasonRestartBlocked, "Change does not restart %s, as it is excluded by whitelisted or blacklisted controllers", describePodController(controller))
+				}
+				continue
+			}
+
+			addMatch(controller, resource, keys)
+		}
+	}
+
+	// Pod controllers can also opt into being managed through their watch annotation,
+	// in which case no reference from their pod templates is required. If they don't
+	// reference a watched resource, we assume they consume all of its keys.
+	for _, controller := range podControllers {
+		annotatedResources, err := getManagedResourcesInAnnotation(controller)
+		if err != nil {
+			logging.Log("Ignoring invalid %s annotation on %s: %v", annotationKey(proto.LabelWatch), controller.getKey(), err)
+			continue
+		}
+
+		for _, resource := range annotatedResources {
+			if !permitted(controller, resource) {
+				logging.Debug("%s watches %s, but is not permitted to be restarted by its whitelisted or blacklisted controllers", controller.getKey(), resource.getDescription())
+				continue
+			}
+
+			keys := controller.getKeyReferences(resource)
+			if keys == nil {
+				keys = proto.AllKeyReferences()
+			}
+			addMatch(controller, resource, keys)
+		}
+	}
+
+	// If auto discovery is enabled, all resources referenced by pod controllers are
+	// managed, in addition to those in config and annotations.
+	for _, controller := range podControllers {
+		discoveredResources, err := getManagedResourcesAutoDiscovered(controller)
+		if err != nil {
+			return err
+		}
+
+		for _, resource := range discoveredResources {
+			if !permitted(controller, resource) {
+				logging.Debug("%s references %s, but is not permitted to be restarted by its whitelisted or blacklisted controllers", controller.getKey(), resource.getDescription())
+				continue
+			}
+
+			addMatch(controller, resource, controller.getKeyReferences(resource))
+		}
+	}
+
+	known := map[changedResource]bool{}
+	for i := range managedResources {
+		if identity, ok := managedResources[i].asChangedResource(); ok {
+			known[identity] = true
+		}
+	}
+	for _, key := range matchedKeys {
+		for _, resource := range matched[key].resources {
+			if identity, ok := resource.asChangedResource(); ok {
+				known[identity] = true
+			}
+		}
+	}
+	knownResources = known
+
+	// Continue replacing pods of ReplicaSets and ReplicationControllers previously
+	// restarted, which don't replace pods by themselves. This is only done in periodic
+	// control loops, leaving our cache enough time to observe pods we last deleted.
+	for _, controller := range podControllers {
+		if changed != nil {
+			break
+		}
+
+		err := replacePodsGradually(ctx, controller)
+		if err != nil {
+			logging.Log("Error replacing pods of %s: %v", controller.getKey(), err)
+		}
+	}
+
+	// Restart pod controllers requiring it. We don't give up on the rest if one fails,
+	// as the failing one will be retried in the next loop anyway.
+	failed := 0
+	for _, key := range matchedKeys {
+		if changed != nil && !matched[key].hasResource(*changed) {
+			continue
+		}
+
+		err := reconcilePodController(ctx, matched[key], pass, changed)
+		if err != nil {
+			logging.Log("Error reconciling %s: %v", key, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to reconcile %d out of %d pod controllers", failed, len(matchedKeys))
 	}
 
+	return nil
 }
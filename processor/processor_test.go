@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"os"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/controllers"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// TestMain starts our controllers against a fake cluster holding the fixtures of all
+// tests, as controllers can only be started once. Tests keep their fixtures in their own
+// namespaces so that they don't affect each other.
+func TestMain(m *testing.M) {
+	config.Config = &proto.OrderConfig{}
+
+	var fixtures []runtime.Object
+	fixtures = append(fixtures, managedResourceFixtures()...)
+	fixtures = append(fixtures, disruptionBudgetFixtures()...)
+	fixtures = append(fixtures, rolloutFixtures()...)
+
+	c := fake.NewClientset(fixtures...)
+	clientSet = c
+
+	stopChan := make(chan struct{})
+	controllers.Init(c, nil, stopChan, 0)
+
+	code := m.Run()
+	close(stopChan)
+	os.Exit(code)
+}
@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/chongyangshi/Order/logging"
+)
+
+// maxQueueRetries is how many times we retry a changed resource from a work queue
+// before dropping it, leaving it to the periodic control loop instead.
+const maxQueueRetries = 5
+
+// changedResource identifies a resource taken from a work queue after it changed,
+// which may or may not be managed by Order.
+type changedResource struct {
+	resourceType string
+	namespace    string
+	name         string
+}
+
+// workInProgress tracks work items being processed by workers during a run of the
+// processor, so that the run can wait for them before it stops. Workers blocked waiting
+// for work items can't be interrupted and outlive the run, so they put back any work
+// items they take after it has stopped.
+type workInProgress struct {
+	lock    sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// start accounts for a work item being processed, returning false if the run has
+// stopped and the work item should be put back instead.
+func (w *workInProgress) start() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.stopped {
+		return false
+	}
+	w.wg.Add(1)
+	return true
+}
+
+// done accounts for a work item having been processed.
+func (w *workInProgress) done() {
+	w.wg.Done()
+}
+
+// stop prevents any more work items from being processed, and waits for those being
+// processed.
+func (w *workInProgress) stop() {
+	w.lock.Lock()
+	w.stopped = true
+	w.lock.Unlock()
+
+	w.wg.Wait()
+}
+
+// runWorker drains a work queue of changed resources of a given type, and reconciles
+// pod controllers referencing each of them, until the queue is shut down or the context
+// is cancelled.
+func runWorker(ctx context.Context, queue workqueue.RateLimitingInterface, resourceType string, work *workInProgress) {
+	for processNextWorkItem(ctx, queue, resourceType, work) {
+	}
+}
+
+func processNextWorkItem(ctx context.Context, queue workqueue.RateLimitingInterface, resourceType string, work *workInProgress) bool {
+	item, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(item)
+
+	// If the processor is stopping, put the item back for when the processor runs again.
+	if ctx.Err() != nil || !work.start() {
+		queue.Add(item)
+		return false
+	}
+	defer work.done()
+
+	key, ok := item.(string)
+	if !ok {
+		// Should never happen
+		queue.Forget(item)
+		return true
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		logging.Log("Discarding invalid %s key %s from work queue: %v", resourceType, key, err)
+		queue.Forget(item)
+		return true
+	}
+
+	logging.Debug("Processing changed %s %s from work queue", resourceType, key)
+	err = reconcile(ctx, &changedResource{
+		resourceType: resourceType,
+		namespace:    namespace,
+		name:         name,
+	})
+	if err != nil {
+		if queue.NumRequeues(item) < maxQueueRetries {
+			logging.Log("Error processing changed %s %s, retrying: %v", resourceType, key, err)
+			queue.AddRateLimited(item)
+			return true
+		}
+
+		logging.Log("Error processing changed %s %s, leaving it to the next control loop: %v", resourceType, key, err)
+	}
+
+	queue.Forget(item)
+	return true
+}
@@ -0,0 +1,343 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/chongyangshi/Order/controllers/cachers"
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
+	"github.com/chongyangshi/Order/proto"
+)
+
+var (
+	// recentRestarts records when we last restarted each pod controller, keyed by
+	// podController.getKey(). Our cachers may lag behind a patch we have just made,
+	// so this prevents us from restarting a pod controller again before the new
+	// last-rolling-restart annotation shows up in cache.
+	recentRestarts     = map[string]time.Time{}
+	recentRestartsLock sync.Mutex
+
+	// dryRunRecords are managed resources hashes and versions we would have recorded on
+	// pod controllers in dry run, keyed by podController.getKey(). They stand in for the
+	// annotations we don't write, so that pod controllers are adopted, and restarts are
+	// reported once for each change.
+	dryRunRecords     = map[string]dryRunRecord{}
+	dryRunRecordsLock sync.Mutex
+
+	// deferredRestarts records the managed resources hash each pod controller was last
+	// reported with as deferred by its restart cooldown, keyed by podController.getKey(),
+	// so that each deferred change is only reported once.
+	deferredRestarts     = map[string]string{}
+	deferredRestartsLock sync.Mutex
+)
+
+// dryRunRecord is what we would have recorded on a pod controller in dry run.
+type dryRunRecord struct {
+	hash     string
+	versions string
+}
+
+// recordDryRun records the managed resources hash and versions on the pod controller in
+// place of patching it in dry run.
+func recordDryRun(c podController, hash, versions string) {
+	dryRunRecordsLock.Lock()
+	defer dryRunRecordsLock.Unlock()
+
+	dryRunRecords[c.getKey()] = dryRunRecord{hash: hash, versions: versions}
+}
+
+// getDryRunRecord returns what we would have recorded on the pod controller in dry run,
+// and whether we have recorded anything.
+func getDryRunRecord(c podController) (dryRunRecord, bool) {
+	dryRunRecordsLock.Lock()
+	defer dryRunRecordsLock.Unlock()
+
+	record, found := dryRunRecords[c.getKey()]
+	return record, found
+}
+
+// reconcilePodController checks whether the managed resources currently loaded by a pod
+// controller are up to date, and if not, queues a rolling restart on it if its restart
+// cooldown permits. If it was reconciled due to a changed resource taken from a work
+// queue, the changed resource is given.
+func reconcilePodController(ctx context.Context, rs *managedResourcesForPodController, pass uint64, changed *changedResource) error {
+	hash, err := rs.getHash()
+	if err != nil {
+		return err
+	}
+
+	versions, err := rs.getVersions()
+	if err != nil {
+		return err
+	}
+
+	controllerKey := rs.controller.getKey()
+	currentHash, found := rs.controller.getRecordedHash()
+	if !found {
+		// We have not seen this pod controller before, and cannot tell which versions of
+		// managed resources its pods are running. Rather than restarting every pod
+		// controller in the cluster when Order is first deployed, we adopt it by recording
+		// the current hash, and restart it when managed resources next change.
+		if isDryRun() {
+			logging.Debug("Dry run: would adopt %s with managed resources hash %s", controllerKey, hash)
+			recordDryRun(rs.controller, hash, versions)
+			return nil
+		}
+
+		logging.Log("Adopting %s with managed resources hash %s", controllerKey, hash)
+		return patchPodController(ctx, rs.controller, hash, versions, false)
+	}
+
+	if currentHash == hash {
+		logging.Debug("%s is up to date with its managed resources", controllerKey)
+		restarts.remove(rs.controller)
+		return nil
+	}
+
+	if rs.controller.job != nil && !rs.recreatesJob() {
+		// Record the hash, so that we only report the Job once for each change.
+		policy := rs.getJobPolicy()
+		if isJobFinished(rs.controller.job) {
+			policy = fmt.Sprintf("%s, as it has finished", policy)
+		}
+		logging.Log("%s is out of date with its managed resources, but will not be re-created under job policy %s", controllerKey, policy)
+		recordEvent(rs.controller.getObject(), corev1.EventTypeWarning, eventReasonOutOfDate, "Out of date with %s, but not re-created under job policy %s", describeResources(rs.getChangedResources(changed)), policy)
+		if isDryRun() {
+			recordDryRun(rs.controller, hash, versions)
+			return nil
+		}
+		return patchPodController(ctx, rs.controller, hash, versions, false)
+	}
+
+	for _, r := range rs.getChangedResources(changed) {
+		if isPoisoned(r) {
+			logging.Debug("%s requires a rolling restart, but the change in %s caused a rollout to fail", controllerKey, r.getDescription())
+			restarts.remove(rs.controller)
+			return nil
+		}
+	}
+
+	cooldown := rs.getRestartCooldown()
+	lastRestart, restarted := getLastRollingRestart(rs.controller)
+	if restarted && time.Since(lastRestart) < cooldown {
+		logging.Debug("%s requires a rolling restart, but was last restarted at %s, within cooldown %v", controllerKey, lastRestart.Format(time.RFC3339), cooldown)
+		// Only report deferrals when a managed resource has changed, rather than in every
+		// control loop during the cooldown.
+		if changed != nil && rs.hasResource(*changed) {
+			reportRestartDeferred(rs.controller, hash, rs.getChangedResources(changed), lastRestart, cooldown)
+		}
+		return nil
+	}
+
+	restarts.push(rs, hash, versions, pass, rs.getChangedResources(changed))
+	return nil
+}
+
+// reportRestartDeferred reports and counts a rolling restart on a pod controller
+// deferred by its restart cooldown, unless it has already been reported for the same
+// hash.
+func reportRestartDeferred(c podController, hash string, changed []*managedResource, lastRestart time.Time, cooldown time.Duration) {
+	deferredRestartsLock.Lock()
+	defer deferredRestartsLock.Unlock()
+
+	if deferredRestarts[c.getKey()] == hash {
+		return
+	}
+	deferredRestarts[c.getKey()] = hash
+
+	metrics.RestartSkipped(c.getObjectMeta().GetNamespace(), c.getKind(), metrics.SkipReasonCooldown)
+	logging.Log("Deferring rolling restart on %s, as it was last restarted at %s, within cooldown %v", c.getKey(), lastRestart.Format(time.RFC3339), cooldown)
+	recordEvent(c.getObject(), corev1.EventTypeWarning, eventReasonRestartDeferred, "Rolling restart due to change in %s deferred, as it was last restarted at %s, within cooldown %v", describeResources(changed), lastRestart.Format(time.RFC3339), cooldown)
+}
+
+// getLastRollingRestart returns when the pod controller was last restarted by Order,
+// and whether it has ever been restarted by Order at all.
+func getLastRollingRestart(c podController) (time.Time, bool) {
+	var lastRestart time.Time
+	restarted := false
+
+	if value, found := c.getAnnotation(proto.LabelLastRollingRestart); found {
+		lastRestart = parseLastRollingRestartTimeBestEffort(value)
+		restarted = true
+	}
+
+	recentRestartsLock.Lock()
+	defer recentRestartsLock.Unlock()
+	if recent, found := recentRestarts[c.getKey()]; found && recent.After(lastRestart) {
+		lastRestart = recent
+		restarted = true
+	}
+
+	return lastRestart, restarted
+}
+
+// patchPodController records the managed resources hash, and versions of each managed
+// resource, on the pod controller. If a restart is requested, it also stamps the restart
+// time onto the pod template, which causes Kubernetes to perform a rolling restart of its
+// pods in the same way as `kubectl rollout restart`. For ReplicaSets and
+// ReplicationControllers, pods are then replaced gradually by replacePodsGradually, and
+// a bare Pod is simply deleted. For a CronJob, the Job template is stamped instead, which
+// only affects future Jobs. A standalone Job is re-created. A custom pod controller has
+// its pod template stamped at the configured path, leaving the rollout to its own
+// controller.
+func patchPodController(ctx context.Context, c podController, hash, versions string, restart bool) error {
+	if restart && c.pod != nil {
+		return deletePod(ctx, c)
+	}
+
+	if restart && c.job != nil {
+		return recreateJob(ctx, c, hash, versions)
+	}
+
+	annotations := map[string]string{
+		annotationKey(proto.LabelManagedResourcesHash):    hash,
+		annotationKey(proto.LabelManagedResourceVersions): versions,
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	}
+
+	now := time.Now().UTC()
+	if restart {
+		restartedAt := now.Format(time.RFC3339)
+		annotations[annotationKey(proto.LabelLastRollingRestart)] = restartedAt
+		templatePatch := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{
+					annotationKey(proto.LabelLastRollingRestart): restartedAt,
+				},
+			},
+		}
+
+		if c.cronJob != nil {
+			// Future Jobs spawned by the CronJob carry the hash of managed resources they
+			// were created with in their pod templates.
+			templatePatch["metadata"] = map[string]interface{}{
+				"annotations": map[string]string{
+					annotationKey(proto.LabelLastRollingRestart):   restartedAt,
+					annotationKey(proto.LabelManagedResourcesHash): hash,
+				},
+			}
+			patch["spec"] = map[string]interface{}{
+				"jobTemplate": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"template": templatePatch,
+					},
+				},
+			}
+		} else if c.custom != nil {
+			// Nest the pod template patch at the configured path of the custom resource
+			path := c.custom.Kind.XXXParsedPodTemplatePath
+			nested := templatePatch
+			for i := len(path) - 1; i > 0; i-- {
+				nested = map[string]interface{}{path[i]: nested}
+			}
+			patch[path[0]] = nested
+		} else {
+			patch["spec"] = map[string]interface{}{
+				"template": templatePatch,
+			}
+		}
+	}
+
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	meta := c.getObjectMeta()
+	switch {
+	case c.daemonSet != nil:
+		_, err = clientSet.AppsV1().DaemonSets(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.deployment != nil:
+		_, err = clientSet.AppsV1().Deployments(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.statefulSet != nil:
+		_, err = clientSet.AppsV1().StatefulSets(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.replicaSet != nil:
+		_, err = clientSet.AppsV1().ReplicaSets(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.replicationController != nil:
+		_, err = clientSet.CoreV1().ReplicationControllers(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.pod != nil:
+		_, err = clientSet.CoreV1().Pods(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.cronJob != nil:
+		_, err = clientSet.BatchV1().CronJobs(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.job != nil:
+		_, err = clientSet.BatchV1().Jobs(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.custom != nil:
+		// Custom resources don't support strategic merge patches
+		_, err = dynamicClient.Resource(c.custom.Resource).Namespace(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	if restart {
+		recentRestartsLock.Lock()
+		recentRestarts[c.getKey()] = now
+		recentRestartsLock.Unlock()
+	}
+
+	return nil
+}
+
+// deletePod restarts a bare Pod which has opted into being restarted by deletion. The
+// Pod is deleted gracefully, and it is up to whoever created it to create it again.
+func deletePod(ctx context.Context, c podController) error {
+	meta := c.getObjectMeta()
+	uid := meta.GetUID()
+	err := clientSet.CoreV1().Pods(meta.GetNamespace()).Delete(ctx, meta.GetName(), metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	})
+	if err != nil {
+		return err
+	}
+
+	recentRestartsLock.Lock()
+	recentRestarts[c.getKey()] = time.Now().UTC()
+	recentRestartsLock.Unlock()
+
+	return nil
+}
+
+// terminateRunningJobs deletes Jobs spawned by a CronJob which are still running, so
+// that they don't keep running with managed resources which have since changed. The
+// CronJob will spawn new Jobs on its schedule.
+func terminateRunningJobs(ctx context.Context, c podController) error {
+	if c.cronJob == nil {
+		return nil
+	}
+
+	jobs, err := cachers.GetJobsControlledBy(c.cronJob.Namespace, c.cronJob.UID)
+	if err != nil {
+		return err
+	}
+
+	propagationPolicy := metav1.DeletePropagationBackground
+	for _, job := range jobs {
+		if job.Status.Active == 0 || job.DeletionTimestamp != nil {
+			continue
+		}
+
+		logging.Log("Terminating running Job %s spawned by %s", job.Name, c.getKey())
+		uid := job.UID
+		err := clientSet.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
+			PropagationPolicy: &propagationPolicy,
+			Preconditions:     &metav1.Preconditions{UID: &uid},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
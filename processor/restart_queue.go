@@ -0,0 +1,395 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// restarts is the restart queue of the currently running processor.
+var restarts *restartQueue
+
+// pendingRestart is a rolling restart due on a pod controller, waiting in the restart
+// queue to be dispatched.
+type pendingRestart struct {
+	controller podController
+	hash       string
+	versions   string
+	cooldown   time.Duration
+	stagger    time.Duration
+
+	// terminateRunningJobs applies to CronJobs only
+	terminateRunningJobs bool
+
+	// changed are the managed resources whose changes the restart is due to
+	changed []*managedResource
+
+	// windows and blackouts determine when the restart may be dispatched
+	windows   [][]*proto.MaintenanceWindow
+	blackouts []*proto.Blackout
+
+	// blockingBudget is the name of the PodDisruptionBudget last found deferring the
+	// restart, so that it is only reported once
+	blockingBudget string
+
+	// pass is the reconciliation pass in which the restart was first queued, restarts
+	// queued in earlier passes are dispatched first.
+	pass uint64
+}
+
+// before determines the order in which pending restarts are dispatched. Restarts queued
+// in earlier reconciliation passes go first, so that pod controllers which keep changing
+// cannot starve others. Within the same pass, restarts are ordered by namespace, name and
+// then kind, so that the order is deterministic across processor restarts.
+func (r *pendingRestart) before(other *pendingRestart) bool {
+	if r.pass != other.pass {
+		return r.pass < other.pass
+	}
+
+	meta, otherMeta := r.controller.getObjectMeta(), other.controller.getObjectMeta()
+	if meta.GetNamespace() != otherMeta.GetNamespace() {
+		return meta.GetNamespace() < otherMeta.GetNamespace()
+	}
+	if meta.GetName() != otherMeta.GetName() {
+		return meta.GetName() < otherMeta.GetName()
+	}
+
+	return r.controller.getKind() < other.controller.getKind()
+}
+
+// nextRestartTime returns the earliest time at or after t when the restart may be
+// dispatched under its maintenance windows and blackouts, and whether there is such a
+// time.
+func (r *pendingRestart) nextRestartTime(t time.Time) (time.Time, bool) {
+	return proto.NextRestartTime(t, r.windows, r.blackouts)
+}
+
+// restartQueue dispatches pending rolling restarts one at a time, at least a pod
+// controller stagger apart from each other, so that a change in a widely referenced
+// managed resource does not restart everything in the cluster at once. If rollouts are
+// health gated, restarts due to the same changes also wait for the rollouts of earlier
+// restarts to become healthy. Restarts also wait for their maintenance windows, and for
+// PodDisruptionBudgets covering their pods to allow disruptions.
+type restartQueue struct {
+	lock    sync.Mutex
+	pending map[string]*pendingRestart
+	pass    uint64
+
+	// nextDispatch is when the stagger after the last rolling restart ends. Besides
+	// restarts dispatched by us, it also accounts for last-rolling-restart annotations
+	// observed on pod controllers, so that the stagger is honoured across processor
+	// restarts.
+	nextDispatch time.Time
+
+	// rollouts are rollouts of restarts we have dispatched, by keys of their pod
+	// controllers, which are watched if rollouts are health gated.
+	rollouts map[string]*rollout
+
+	// poisoned are versions of managed resources whose changes caused rollouts to fail,
+	// for which no pod controllers are restarted any more
+	poisoned map[string]bool
+
+	wake chan struct{}
+}
+
+func newRestartQueue() *restartQueue {
+	return &restartQueue{
+		pending:  map[string]*pendingRestart{},
+		rollouts: map[string]*rollout{},
+		poisoned: map[string]bool{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// nextPass starts a new reconciliation pass, returning its sequence number.
+func (q *restartQueue) nextPass() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.pass++
+	return q.pass
+}
+
+// push queues a rolling restart on a pod controller. If one is already pending for the
+// pod controller, it keeps its position in the queue, but will restart the pod controller
+// with the latest hash and timings, and for changes in both the managed resources it was
+// previously queued for and those given.
+func (q *restartQueue) push(rs *managedResourcesForPodController, hash, versions string, pass uint64, changed []*managedResource) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := rs.controller.getKey()
+	if existing, found := q.pending[key]; found {
+		existing.controller = rs.controller
+		existing.hash = hash
+		existing.versions = versions
+		existing.cooldown = rs.getRestartCooldown()
+		existing.stagger = rs.getPodControllerStagger()
+		existing.terminateRunningJobs = rs.terminatesRunningJobs()
+		existing.changed = mergeManagedResources(existing.changed, changed)
+		existing.windows = rs.getMaintenanceWindows()
+		existing.blackouts = rs.getBlackouts()
+		return
+	}
+
+	r := &pendingRestart{
+		controller: rs.controller,
+		hash:       hash,
+		versions:   versions,
+		cooldown:   rs.getRestartCooldown(),
+		stagger:    rs.getPodControllerStagger(),
+		pass:       pass,
+
+		terminateRunningJobs: rs.terminatesRunningJobs(),
+		changed:              changed,
+		windows:              rs.getMaintenanceWindows(),
+		blackouts:            rs.getBlackouts(),
+	}
+	q.pending[key] = r
+	logging.Log("Queued rolling restart on %s, %d restarts now pending", key, len(q.pending))
+
+	now := time.Now()
+	if at, found := r.nextRestartTime(now); !found {
+		logging.Log("Rolling restart on %s will stay pending, as none of its maintenance windows opens outside blackouts", key)
+		recordEvent(r.controller.getObject(), corev1.EventTypeWarning, eventReasonRestartDeferred, "Rolling restart due to change in %s deferred, as none of its maintenance windows opens outside blackouts", describeResources(changed))
+	} else if at.After(now) {
+		logging.Log("Rolling restart on %s is outside maintenance windows or within a blackout, and will stay pending until %s", key, at.Format(time.RFC3339))
+		recordEvent(r.controller.getObject(), corev1.EventTypeNormal, eventReasonRestartDeferred, "Rolling restart due to change in %s deferred until %s, outside maintenance windows or within a blackout", describeResources(changed), at.Format(time.RFC3339))
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove drops any pending restart on a pod controller, if it no longer requires one.
+func (q *restartQueue) remove(c podController) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := c.getKey()
+	if _, found := q.pending[key]; found {
+		logging.Debug("Dropping pending rolling restart on %s as it is no longer required", key)
+		delete(q.pending, key)
+	}
+}
+
+// observeRestart accounts for a rolling restart performed at the given time, which may
+// have been performed by a previous run of the processor. As we can't tell what stagger
+// applied to it, the default stagger is assumed.
+func (q *restartQueue) observeRestart(t time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// Don't let clock skew hold up restarts indefinitely.
+	if now := time.Now(); t.After(now) {
+		t = now
+	}
+
+	if next := t.Add(config.Config.XXXParsedPodControllerStagger); next.After(q.nextDispatch) {
+		q.nextDispatch = next
+	}
+}
+
+// pop removes and returns the next pending restart if the stagger since the last
+// restart has passed, skipping those held by rollouts, those outside maintenance
+// windows or within blackouts, and those whose pods are covered by PodDisruptionBudgets
+// allowing no disruptions. Otherwise, it returns how long to wait before trying
+// again.
+func (q *restartQueue) pop() (*pendingRestart, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := time.Now()
+	var next *pendingRestart
+	var ready, held, waiting, disrupted int
+	var nextWindow time.Time
+	for _, r := range q.pending {
+		if q.isHeld(r) {
+			held++
+			continue
+		}
+
+		if at, found := r.nextRestartTime(now); !found || at.After(now) {
+			waiting++
+			if found && (nextWindow.IsZero() || at.Before(nextWindow)) {
+				nextWindow = at
+			}
+			continue
+		}
+
+		if pdb := r.controller.getBlockingDisruptionBudget(); pdb != nil {
+			disrupted++
+			reportBlockingBudget(r, pdb)
+			continue
+		}
+		r.blockingBudget = ""
+
+		ready++
+		if next == nil || r.before(next) {
+			next = r
+		}
+	}
+	metrics.SetPendingRestarts(ready, held, waiting, disrupted, nextWindow)
+
+	if next == nil {
+		wait := config.Config.XXXParsedPodControllerStagger
+		if held > 0 || disrupted > 0 {
+			// Pending restarts are held until rollouts become healthy, or until
+			// disruption budgets allow disruptions again
+			wait = rolloutCheckInterval
+		}
+		if !nextWindow.IsZero() && nextWindow.Sub(now) < wait {
+			wait = nextWindow.Sub(now)
+		}
+		return nil, wait
+	}
+
+	if wait := time.Until(q.nextDispatch); wait > 0 {
+		return nil, wait
+	}
+
+	delete(q.pending, next.controller.getKey())
+
+	return next, 0
+}
+
+// staggerAfter starts the stagger after a rolling restart has been performed. Restarts
+// which are popped but then not performed don't hold up those behind them.
+func (q *restartQueue) staggerAfter(r *pendingRestart) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if next := time.Now().Add(r.stagger); next.After(q.nextDispatch) {
+		q.nextDispatch = next
+	}
+}
+
+// reportBlockingBudget reports a pending restart deferred by a PodDisruptionBudget
+// allowing no disruptions, unless it has already been reported for the same budget.
+func reportBlockingBudget(r *pendingRestart, pdb *policyv1.PodDisruptionBudget) {
+	if r.blockingBudget == pdb.Name {
+		return
+	}
+	r.blockingBudget = pdb.Name
+
+	namespace, kind := r.controller.getObjectMeta().GetNamespace(), r.controller.getKind()
+	logging.Log("Deferring rolling restart on %s, as PodDisruptionBudget %s/%s currently allows no disruptions", r.controller.getKey(), pdb.Namespace, pdb.Name)
+	metrics.RestartSkipped(namespace, kind, metrics.SkipReasonDisruptionBudget)
+	recordEvent(r.controller.getObject(), corev1.EventTypeWarning, eventReasonRestartDeferred, "Rolling restart due to change in %s deferred, as PodDisruptionBudget %s currently allows no disruptions", describeResources(r.changed), pdb.Name)
+}
+
+// run dispatches pending restarts until the context is cancelled.
+func (q *restartQueue) run(ctx context.Context) {
+	for {
+		q.checkRollouts(ctx)
+
+		next, wait := q.pop()
+		if next != nil {
+			if dispatchRestart(ctx, next) {
+				q.staggerAfter(next)
+				q.startRollout(next)
+			}
+			continue
+		}
+
+		// Keep checking rollouts being watched while waiting
+		q.lock.Lock()
+		if len(q.rollouts) > 0 && wait > rolloutCheckInterval {
+			wait = rolloutCheckInterval
+		}
+		q.lock.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// dispatchRestart performs a pending rolling restart, returning whether it was performed.
+// Its cooldown is checked again, as the pod controller may have been restarted while the
+// restart was pending. If it fails, it will be queued again by the next reconciliation.
+// In dry run, the restart is only reported.
+func dispatchRestart(ctx context.Context, r *pendingRestart) bool {
+	controllerKey := r.controller.getKey()
+	namespace, kind := r.controller.getObjectMeta().GetNamespace(), r.controller.getKind()
+
+	lastRestart, restarted := getLastRollingRestart(r.controller)
+	if restarted && time.Since(lastRestart) < r.cooldown {
+		logging.Debug("Skipping pending rolling restart on %s, as it was last restarted at %s, within cooldown %v", controllerKey, lastRestart.Format(time.RFC3339), r.cooldown)
+		reportRestartDeferred(r.controller, r.hash, r.changed, lastRestart, r.cooldown)
+		return false
+	}
+
+	if isDryRun() {
+		logging.Log("Dry run: would perform rolling restart on %s due to change in %s", controllerKey, describeResources(r.changed))
+		metrics.DryRunRestart(namespace, kind)
+		recordEvent(r.controller.getObject(), corev1.EventTypeNormal, eventReasonDryRunRollingRestart, "Would restart due to change in %s, but Order is in dry run", describeResources(r.changed))
+
+		// Pretend the restart was performed, so that cooldown applies as it would have,
+		// and the restart is only reported once for this change
+		recentRestartsLock.Lock()
+		recentRestarts[controllerKey] = time.Now().UTC()
+		recentRestartsLock.Unlock()
+		recordDryRun(r.controller, r.hash, r.versions)
+		return false
+	}
+
+	logging.Log("Performing rolling restart on %s as its managed resources have changed", controllerKey)
+	err := patchPodController(ctx, r.controller, r.hash, r.versions, true)
+	if err != nil {
+		logging.Log("Error performing rolling restart on %s: %v", controllerKey, err)
+		return false
+	}
+	metrics.RestartPerformed(namespace, kind)
+
+	recordEvent(r.controller.getObject(), corev1.EventTypeNormal, eventReasonRollingRestart, "Restarted due to change in %s", describeResources(r.changed))
+	for _, resource := range r.changed {
+		recordEvent(resource.getObject(), corev1.EventTypeNormal, eventReasonRollingRestart, "Restarted %s due to this change", describePodController(r.controller))
+	}
+
+	if r.terminateRunningJobs {
+		err = terminateRunningJobs(ctx, r.controller)
+		if err != nil {
+			logging.Log("Error terminating running Jobs of %s: %v", controllerKey, err)
+		}
+	}
+
+	return true
+}
+
+// mergeManagedResources adds managed resources to existing ones, replacing existing ones
+// with the same UIDs, which are of older versions.
+func mergeManagedResources(existing, resources []*managedResource) []*managedResource {
+	merged := resources
+	for _, r := range existing {
+		replaced := false
+		for _, newer := range resources {
+			if newer.getUID() == r.getUID() {
+				replaced = true
+				break
+			}
+		}
+
+		if !replaced {
+			merged = append(merged, r)
+		}
+	}
+
+	return merged
+}
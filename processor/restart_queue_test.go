@@ -0,0 +1,106 @@
+package processor
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/proto"
+)
+
+func testPendingRestart(kind, namespace, name string, pass uint64) *pendingRestart {
+	meta := metav1.ObjectMeta{Namespace: namespace, Name: name}
+
+	var c podController
+	switch kind {
+	case proto.PodControllerTypeStatefulSets:
+		c.statefulSet = &appsv1.StatefulSet{ObjectMeta: meta}
+	default:
+		c.deployment = &appsv1.Deployment{ObjectMeta: meta}
+	}
+
+	return &pendingRestart{controller: c, pass: pass}
+}
+
+func TestPendingRestartBefore(t *testing.T) {
+	deployment := proto.PodControllerTypeDeployments
+	statefulSet := proto.PodControllerTypeStatefulSets
+
+	tests := []struct {
+		name   string
+		r      *pendingRestart
+		other  *pendingRestart
+		expect bool
+	}{
+		{"earlier pass", testPendingRestart(deployment, "b", "b", 1), testPendingRestart(deployment, "a", "a", 2), true},
+		{"later pass", testPendingRestart(deployment, "a", "a", 2), testPendingRestart(deployment, "b", "b", 1), false},
+		{"namespace within pass", testPendingRestart(deployment, "a", "b", 1), testPendingRestart(deployment, "b", "a", 1), true},
+		{"later namespace within pass", testPendingRestart(deployment, "b", "a", 1), testPendingRestart(deployment, "a", "b", 1), false},
+		{"name within namespace", testPendingRestart(statefulSet, "a", "a", 1), testPendingRestart(deployment, "a", "b", 1), true},
+		{"later name within namespace", testPendingRestart(deployment, "a", "b", 1), testPendingRestart(statefulSet, "a", "a", 1), false},
+		{"kind with same name", testPendingRestart(deployment, "a", "a", 1), testPendingRestart(statefulSet, "a", "a", 1), true},
+		{"later kind with same name", testPendingRestart(statefulSet, "a", "a", 1), testPendingRestart(deployment, "a", "a", 1), false},
+		{"same pod controller", testPendingRestart(deployment, "a", "a", 1), testPendingRestart(deployment, "a", "a", 1), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if before := test.r.before(test.other); before != test.expect {
+				t.Errorf("Expected %s before %s: %v, got %v", test.r.controller.getKey(), test.other.controller.getKey(), test.expect, before)
+			}
+		})
+	}
+}
+
+func TestRestartQueuePassFairness(t *testing.T) {
+	config.Config = &proto.OrderConfig{}
+
+	push := func(q *restartQueue, name string, pass uint64) {
+		c := testPendingRestart(proto.PodControllerTypeDeployments, "queue", name, pass).controller
+		q.push(&managedResourcesForPodController{controller: c}, "hash", "", pass, nil)
+	}
+
+	tests := []struct {
+		name   string
+		passes [][]string
+		expect []string
+	}{
+		{"ordered by name within a pass", [][]string{{"web", "api", "cache"}}, []string{"api", "cache", "web"}},
+		{"earlier passes first", [][]string{{"web"}, {"api"}, {"cache"}}, []string{"web", "api", "cache"}},
+		{"queued again keeps its position", [][]string{{"yak", "zebra"}, {"ant", "zebra"}, {"zebra"}}, []string{"yak", "zebra", "ant"}},
+		{"changing pod controllers can't starve others", [][]string{{"zebra"}, {"ant", "zebra"}, {"bee", "zebra"}}, []string{"zebra", "ant", "bee"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := newRestartQueue()
+			for _, names := range test.passes {
+				pass := q.nextPass()
+				for _, name := range names {
+					push(q, name, pass)
+				}
+			}
+
+			var popped []string
+			for range test.expect {
+				next, wait := q.pop()
+				if next == nil {
+					t.Fatalf("Expected a pending restart, got none with wait %v after %v", wait, popped)
+				}
+				popped = append(popped, next.controller.getObjectMeta().GetName())
+			}
+
+			if next, _ := q.pop(); next != nil {
+				t.Errorf("Expected no more pending restarts, got %s", next.controller.getKey())
+			}
+
+			for i := range test.expect {
+				if popped[i] != test.expect[i] {
+					t.Fatalf("Expected restarts dispatched in order %v, got %v", test.expect, popped)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/controllers"
+	"github.com/chongyangshi/Order/controllers/cachers"
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// rolloutCheckInterval is how often we check the health of rollouts in progress.
+const rolloutCheckInterval = time.Second * 5
+
+// crashLoopBackOff is the waiting reason of containers crashing repeatedly.
+const crashLoopBackOff = "CrashLoopBackOff"
+
+type rolloutStatus int
+
+const (
+	rolloutProgressing rolloutStatus = iota
+	rolloutHealthy
+	rolloutFailed
+)
+
+// rollout is a rolling restart we have dispatched on a pod controller, which holds
+// further restarts for the same changes in managed resources until it becomes healthy.
+type rollout struct {
+	controller podController
+	deadline   time.Time
+
+	// resources are the managed resources which changed since the pod controller was
+	// last restarted, which the restart was due to, and changes are their versions.
+	// Unchanged managed resources the pod controller shares with others are left out,
+	// so that unrelated restarts are not held.
+	resources []*managedResource
+	changes   map[string]bool
+
+	// restartedAt is the last-rolling-restart annotation we stamped onto the pod template,
+	// which identifies pods created by the rollout
+	restartedAt string
+
+	// previousRestart is the last-rolling-restart annotation of the pod template before
+	// the restart, or nil if it had none, which is restored when rolling back
+	previousRestart *string
+
+	// failed is set once the rollout has not become healthy within its deadline
+	failed bool
+}
+
+// getChangeVersions returns strings identifying the versions of managed resources,
+// regardless of which of their keys are consumed by pod controllers.
+func getChangeVersions(resources []*managedResource) []string {
+	var versions []string
+	for _, r := range resources {
+		versions = append(versions, r.getVersion(proto.AllKeyReferences()))
+	}
+
+	return versions
+}
+
+// startRollout starts watching the rollout of a restart just dispatched, if rollouts
+// are health gated.
+func (q *restartQueue) startRollout(r *pendingRestart) {
+	deadline := config.Config.XXXParsedRolloutDeadline
+	if deadline == 0 {
+		return
+	}
+
+	key := r.controller.getKey()
+	ro := &rollout{
+		controller: r.controller,
+		deadline:   time.Now().Add(deadline),
+		resources:  r.changed,
+		changes:    map[string]bool{},
+	}
+	for _, version := range getChangeVersions(r.changed) {
+		ro.changes[version] = true
+	}
+
+	// The pod controller was taken from our cache before the restart
+	if previous, found := r.controller.getPodTemplateAnnotations()[annotationKey(proto.LabelLastRollingRestart)]; found {
+		ro.previousRestart = &previous
+	}
+
+	recentRestartsLock.Lock()
+	ro.restartedAt = recentRestarts[key].Format(time.RFC3339)
+	recentRestartsLock.Unlock()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.rollouts[key] = ro
+}
+
+// isHeld returns whether a pending restart is held by a rollout in progress or failed
+// for any of the changes it is due to, or if any of those changes is poisoned. The lock
+// must be held by the caller.
+func (q *restartQueue) isHeld(r *pendingRestart) bool {
+	key := r.controller.getKey()
+	for _, version := range getChangeVersions(r.changed) {
+		if q.poisoned[version] {
+			return true
+		}
+
+		for rolloutKey, ro := range q.rollouts {
+			if rolloutKey != key && ro.changes[version] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isPoisoned returns whether a managed resource has changed in a way which caused a
+// rollout to fail, and no pod controllers should be restarted for it.
+func isPoisoned(r *managedResource) bool {
+	if value, found := r.getAnnotation(proto.LabelPoisonedHash); found && value == r.getContentHash() {
+		return true
+	}
+
+	restarts.lock.Lock()
+	defer restarts.lock.Unlock()
+
+	return restarts.poisoned[r.getVersion(proto.AllKeyReferences())]
+}
+
+// checkRollouts updates the health of rollouts being watched, releasing restarts held
+// by those which have become healthy, and halting those held by those which have failed.
+func (q *restartQueue) checkRollouts(ctx context.Context) {
+	q.lock.Lock()
+	watching := map[string]*rollout{}
+	for key, ro := range q.rollouts {
+		watching[key] = ro
+	}
+	q.lock.Unlock()
+
+	for key, ro := range watching {
+		status, reason, err := getRolloutStatus(ctx, ro)
+		if errors.IsNotFound(err) {
+			logging.Log("%s no longer exists, no longer watching its rollout", key)
+			status = rolloutHealthy
+		} else if err != nil {
+			logging.Log("Error checking rollout of %s: %v", key, err)
+			continue
+		}
+
+		q.lock.Lock()
+		if q.rollouts[key] != ro {
+			// Replaced by a newer rollout while we were checking
+			q.lock.Unlock()
+			continue
+		}
+
+		switch {
+		case status == rolloutHealthy:
+			logging.Log("Rollout of %s is healthy, releasing restarts held for the same changes", key)
+			delete(q.rollouts, key)
+		case status == rolloutFailed:
+			delete(q.rollouts, key)
+			for version := range ro.changes {
+				q.poisoned[version] = true
+			}
+		case !ro.failed && time.Now().After(ro.deadline):
+			logging.Log("Rollout of %s has not become healthy within deadline %v, holding restarts for the same changes until it does", key, config.Config.XXXParsedRolloutDeadline)
+			ro.failed = true
+		}
+		q.lock.Unlock()
+
+		if status == rolloutFailed {
+			haltRollout(ctx, ro, reason)
+		}
+	}
+}
+
+// haltRollout marks managed resources whose changes caused a rollout to fail as poisoned,
+// and rolls back the pod controller if configured to.
+func haltRollout(ctx context.Context, ro *rollout, reason string) {
+	key := ro.controller.getKey()
+	logging.Log("Rollout of %s has failed as %s, halting restarts due to change in %s", key, reason, describeResources(ro.resources))
+	recordEvent(ro.controller.getObject(), corev1.EventTypeWarning, eventReasonRolloutFailed, "Rollout due to change in %s failed as %s, halting restarts of other pod controllers for this change", describeResources(ro.resources), reason)
+
+	// Only managed resources known to have changed are poisoned, as poisoning those
+	// which haven't would block restarts of every other pod controller using them.
+	if len(ro.resources) == 0 {
+		logging.Log("Could not tell which managed resources of %s changed, not marking any as poisoned", key)
+	}
+	for _, r := range ro.resources {
+		recordEvent(r.getObject(), corev1.EventTypeWarning, eventReasonRolloutFailed, "Change caused rollout of %s to fail as %s, halting restarts of other pod controllers for this change", describePodController(ro.controller), reason)
+
+		err := markPoisoned(ctx, r)
+		if err != nil {
+			logging.Log("Error marking %s as poisoned: %v", r.getDescription(), err)
+		}
+	}
+
+	if !config.Config.RollbackOnFailure {
+		return
+	}
+
+	err := rollbackPodController(ctx, ro)
+	if err != nil {
+		logging.Log("Error rolling back %s: %v", key, err)
+		return
+	}
+
+	logging.Log("Rolled back %s to its pod template before the failed rollout", key)
+	recordEvent(ro.controller.getObject(), corev1.EventTypeWarning, eventReasonRolledBack, "Rolled back to pod template before the failed rollout due to change in %s", describeResources(ro.resources))
+}
+
+// markPoisoned records on a managed resource that its current content caused a rollout
+// to fail, so that this survives restarts of Order and changes in leadership.
+func markPoisoned(ctx context.Context, r *managedResource) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				annotationKey(proto.LabelPoisonedHash): r.getContentHash(),
+			},
+		},
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case r.secret != nil:
+		_, err = clientSet.CoreV1().Secrets(r.secret.Namespace).Patch(ctx, r.secret.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case r.configMap != nil:
+		_, err = clientSet.CoreV1().ConfigMaps(r.configMap.Namespace).Patch(ctx, r.configMap.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case r.custom != nil:
+		// Custom resources don't support strategic merge patches, the patch is the same as
+		// a JSON merge patch here.
+		resource, err := controllers.GetCustomResourceType(r.custom.GroupVersionKind())
+		if err != nil {
+			return err
+		}
+		_, err = dynamicClient.Resource(resource).Namespace(r.custom.GetNamespace()).Patch(ctx, r.custom.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+		return err
+	}
+
+	return err
+}
+
+// rollbackPodController restores the last-rolling-restart annotation of the pod template
+// from before the failed rollout, which for a Deployment brings back its previous
+// ReplicaSet, in the same way as `kubectl rollout undo`.
+func rollbackPodController(ctx context.Context, ro *rollout) error {
+	// A null value removes the annotation, if the pod template did not have it before
+	var previous interface{}
+	if ro.previousRestart != nil {
+		previous = *ro.previousRestart
+	}
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{
+						annotationKey(proto.LabelLastRollingRestart): previous,
+					},
+				},
+			},
+		},
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	c := ro.controller
+	meta := c.getObjectMeta()
+	switch {
+	case c.deployment != nil:
+		_, err = clientSet.AppsV1().Deployments(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.statefulSet != nil:
+		_, err = clientSet.AppsV1().StatefulSets(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case c.daemonSet != nil:
+		_, err = clientSet.AppsV1().DaemonSets(meta.GetNamespace()).Patch(ctx, meta.GetName(), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	default:
+		return fmt.Errorf("rolling back %s is not supported", c.getKind())
+	}
+
+	return err
+}
+
+// getRolloutStatus returns whether the latest rollout of a pod controller has completed
+// with all pods available, or has failed and why, based on its current status from the
+// API rather than our cache, which may lag behind our restart.
+func getRolloutStatus(ctx context.Context, ro *rollout) (rolloutStatus, string, error) {
+	c := ro.controller
+	meta := c.getObjectMeta()
+	switch {
+	case c.deployment != nil:
+		deploy, err := clientSet.AppsV1().Deployments(meta.GetNamespace()).Get(ctx, meta.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return rolloutProgressing, "", err
+		}
+
+		// The progress condition may be left over from before the restart, until the
+		// Deployment controller has observed the restart.
+		restartedAt, _ := time.Parse(time.RFC3339, ro.restartedAt)
+		if deploy.Status.ObservedGeneration >= deploy.Generation {
+			for _, condition := range deploy.Status.Conditions {
+				if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" && !condition.LastUpdateTime.Time.Before(restartedAt) {
+					return rolloutFailed, "it exceeded its progress deadline", nil
+				}
+			}
+		}
+
+		if reason, crashing, err := hasCrashingPods(deploy.Namespace, deploy.Spec.Selector, ro.restartedAt); err != nil {
+			return rolloutProgressing, "", err
+		} else if crashing {
+			return rolloutFailed, reason, nil
+		}
+
+		replicas := replicasOrDefault(deploy.Spec.Replicas)
+		if deploy.Status.ObservedGeneration >= deploy.Generation &&
+			deploy.Status.UpdatedReplicas == replicas &&
+			deploy.Status.Replicas == replicas &&
+			deploy.Status.AvailableReplicas == replicas {
+			return rolloutHealthy, "", nil
+		}
+
+	case c.statefulSet != nil:
+		sts, err := clientSet.AppsV1().StatefulSets(meta.GetNamespace()).Get(ctx, meta.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return rolloutProgressing, "", err
+		}
+
+		// Pods are only replaced when deleted by someone else
+		if sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+			return rolloutHealthy, "", nil
+		}
+
+		if reason, crashing, err := hasCrashingPods(sts.Namespace, sts.Spec.Selector, ro.restartedAt); err != nil {
+			return rolloutProgressing, "", err
+		} else if crashing {
+			return rolloutFailed, reason, nil
+		}
+
+		replicas := replicasOrDefault(sts.Spec.Replicas)
+		if sts.Status.ObservedGeneration >= sts.Generation &&
+			sts.Status.CurrentRevision == sts.Status.UpdateRevision &&
+			sts.Status.ReadyReplicas == replicas {
+			return rolloutHealthy, "", nil
+		}
+
+	case c.daemonSet != nil:
+		ds, err := clientSet.AppsV1().DaemonSets(meta.GetNamespace()).Get(ctx, meta.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return rolloutProgressing, "", err
+		}
+
+		// Pods are only replaced when deleted by someone else
+		if ds.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType {
+			return rolloutHealthy, "", nil
+		}
+
+		if reason, crashing, err := hasCrashingPods(ds.Namespace, ds.Spec.Selector, ro.restartedAt); err != nil {
+			return rolloutProgressing, "", err
+		} else if crashing {
+			return rolloutFailed, reason, nil
+		}
+
+		if ds.Status.ObservedGeneration >= ds.Generation &&
+			ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled &&
+			ds.Status.NumberUnavailable == 0 {
+			return rolloutHealthy, "", nil
+		}
+
+	default:
+		// Other pod controllers don't report rollout health
+		return rolloutHealthy, "", nil
+	}
+
+	return rolloutProgressing, "", nil
+}
+
+// hasCrashingPods returns whether any pod created by a rollout, as identified by the
+// last-rolling-restart annotation stamped onto its pod template, has a container in
+// CrashLoopBackOff, and which.
+func hasCrashingPods(namespace string, labelSelector *metav1.LabelSelector, restartedAt string) (string, bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return "", false, err
+	}
+
+	pods, err := cachers.GetPodsBySelector(namespace, selector)
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, pod := range pods {
+		if pod.Annotations[annotationKey(proto.LabelLastRollingRestart)] != restartedAt {
+			continue
+		}
+
+		statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason == crashLoopBackOff {
+				return fmt.Sprintf("container %s of pod %s is in %s", status.Name, pod.Name, crashLoopBackOff), true, nil
+			}
+		}
+	}
+
+	return "", false, nil
+}
@@ -0,0 +1,289 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/chongyangshi/Order/proto"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+// testRestartedAt is the last-rolling-restart annotation of rollouts in tests.
+const testRestartedAt = "2026-10-17T12:00:00Z"
+
+func testPod(namespace, name, app, restartedAt string, initStatus, status *corev1.ContainerState) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   namespace,
+		Name:        name,
+		Labels:      map[string]string{"app": app},
+		Annotations: map[string]string{annotationKey(proto.LabelLastRollingRestart): restartedAt},
+	}}
+	if initStatus != nil {
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "init", State: *initStatus}}
+	}
+	if status != nil {
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "app", State: *status}}
+	}
+
+	return pod
+}
+
+func waitingState(reason string) *corev1.ContainerState {
+	return &corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}
+}
+
+func rolloutFixtures() []runtime.Object {
+	before := metav1.NewTime(time.Date(2026, 10, 17, 11, 0, 0, 0, time.UTC))
+	after := metav1.NewTime(time.Date(2026, 10, 17, 12, 5, 0, 0, time.UTC))
+	running := &corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+
+	deployment := func(name string, generation, observedGeneration int64, updated, available int32, progressUpdated *metav1.Time) *appsv1.Deployment {
+		d := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "rollouts", Name: name, Generation: generation},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: int32Ptr(2),
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			},
+			Status: appsv1.DeploymentStatus{
+				ObservedGeneration: observedGeneration,
+				Replicas:           2,
+				UpdatedReplicas:    updated,
+				AvailableReplicas:  available,
+			},
+		}
+		if progressUpdated != nil {
+			d.Status.Conditions = []appsv1.DeploymentCondition{{
+				Type:           appsv1.DeploymentProgressing,
+				Status:         corev1.ConditionFalse,
+				Reason:         "ProgressDeadlineExceeded",
+				LastUpdateTime: *progressUpdated,
+			}}
+		}
+		return d
+	}
+	statefulSet := func(name string, strategy appsv1.StatefulSetUpdateStrategyType, updateRevision string, ready int32) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "rollouts", Name: name, Generation: 1},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas:       int32Ptr(2),
+				Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+				UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: strategy},
+			},
+			Status: appsv1.StatefulSetStatus{
+				ObservedGeneration: 1,
+				CurrentRevision:    "web-1",
+				UpdateRevision:     updateRevision,
+				ReadyReplicas:      ready,
+			},
+		}
+	}
+	daemonSet := func(name string, strategy appsv1.DaemonSetUpdateStrategyType, updated, unavailable int32) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "rollouts", Name: name, Generation: 1},
+			Spec: appsv1.DaemonSetSpec{
+				Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+				UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: strategy},
+			},
+			Status: appsv1.DaemonSetStatus{
+				ObservedGeneration:     1,
+				DesiredNumberScheduled: 3,
+				UpdatedNumberScheduled: updated,
+				NumberUnavailable:      unavailable,
+			},
+		}
+	}
+
+	return []runtime.Object{
+		deployment("healthy", 2, 2, 2, 2, nil),
+		deployment("unobserved", 3, 2, 2, 2, nil),
+		deployment("updating", 2, 2, 1, 2, nil),
+		deployment("deadline-exceeded", 2, 2, 1, 1, &after),
+		deployment("deadline-exceeded-before-restart", 2, 2, 1, 1, &before),
+		deployment("deadline-exceeded-unobserved", 3, 2, 1, 1, &after),
+		deployment("crashing", 2, 2, 1, 1, nil),
+		testPod("rollouts", "crashing-1", "crashing", testRestartedAt, nil, waitingState(crashLoopBackOff)),
+		deployment("crashed-before-restart", 2, 2, 1, 1, nil),
+		testPod("rollouts", "crashed-before-restart-1", "crashed-before-restart", "2026-10-16T12:00:00Z", nil, waitingState(crashLoopBackOff)),
+		statefulSet("sts-healthy", appsv1.RollingUpdateStatefulSetStrategyType, "web-1", 2),
+		statefulSet("sts-updating", appsv1.RollingUpdateStatefulSetStrategyType, "web-2", 2),
+		statefulSet("sts-on-delete", appsv1.OnDeleteStatefulSetStrategyType, "web-2", 1),
+		daemonSet("ds-healthy", appsv1.RollingUpdateDaemonSetStrategyType, 3, 0),
+		daemonSet("ds-unavailable", appsv1.RollingUpdateDaemonSetStrategyType, 3, 1),
+		daemonSet("ds-on-delete", appsv1.OnDeleteDaemonSetStrategyType, 1, 0),
+
+		testPod("crashing", "api-1", "api", testRestartedAt, nil, waitingState(crashLoopBackOff)),
+		testPod("crashing", "init-1", "init", testRestartedAt, waitingState(crashLoopBackOff), waitingState("PodInitializing")),
+		testPod("crashing", "worker-1", "worker", "2026-10-16T12:00:00Z", nil, waitingState(crashLoopBackOff)),
+		testPod("crashing", "web-1", "web", testRestartedAt, nil, waitingState("ContainerCreating")),
+		testPod("crashing", "web-2", "web", testRestartedAt, nil, running),
+	}
+}
+
+func TestGetRolloutStatus(t *testing.T) {
+	objectMeta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Namespace: "rollouts", Name: name}
+	}
+	deployment := func(name string) podController {
+		return podController{deployment: &appsv1.Deployment{ObjectMeta: objectMeta(name)}}
+	}
+	statefulSet := func(name string) podController {
+		return podController{statefulSet: &appsv1.StatefulSet{ObjectMeta: objectMeta(name)}}
+	}
+	daemonSet := func(name string) podController {
+		return podController{daemonSet: &appsv1.DaemonSet{ObjectMeta: objectMeta(name)}}
+	}
+
+	tests := []struct {
+		name         string
+		controller   podController
+		expect       rolloutStatus
+		expectReason string
+	}{
+		{"Deployment healthy", deployment("healthy"), rolloutHealthy, ""},
+		{"Deployment restart not yet observed", deployment("unobserved"), rolloutProgressing, ""},
+		{"Deployment updating", deployment("updating"), rolloutProgressing, ""},
+		{"Deployment exceeded progress deadline", deployment("deadline-exceeded"), rolloutFailed, "it exceeded its progress deadline"},
+		{"Deployment exceeded progress deadline before restart", deployment("deadline-exceeded-before-restart"), rolloutProgressing, ""},
+		{"Deployment progress deadline with restart not yet observed", deployment("deadline-exceeded-unobserved"), rolloutProgressing, ""},
+		{"Deployment with crashing pods", deployment("crashing"), rolloutFailed, "container app of pod crashing-1 is in CrashLoopBackOff"},
+		{"Deployment with pods crashing before restart", deployment("crashed-before-restart"), rolloutProgressing, ""},
+		{"StatefulSet healthy", statefulSet("sts-healthy"), rolloutHealthy, ""},
+		{"StatefulSet updating", statefulSet("sts-updating"), rolloutProgressing, ""},
+		{"StatefulSet updated on delete", statefulSet("sts-on-delete"), rolloutHealthy, ""},
+		{"DaemonSet healthy", daemonSet("ds-healthy"), rolloutHealthy, ""},
+		{"DaemonSet with unavailable pods", daemonSet("ds-unavailable"), rolloutProgressing, ""},
+		{"DaemonSet updated on delete", daemonSet("ds-on-delete"), rolloutHealthy, ""},
+		{"CronJob doesn't report rollout health", podController{cronJob: &batchv1.CronJob{ObjectMeta: objectMeta("report")}}, rolloutHealthy, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ro := &rollout{controller: test.controller, restartedAt: testRestartedAt}
+			status, reason, err := getRolloutStatus(context.Background(), ro)
+			if err != nil {
+				t.Fatalf("Error getting rollout status: %v", err)
+			}
+
+			if status != test.expect {
+				t.Errorf("Expected rollout status %v, got %v", test.expect, status)
+			}
+			if reason != test.expectReason {
+				t.Errorf("Expected reason %q, got %q", test.expectReason, reason)
+			}
+		})
+	}
+}
+
+func TestGetRolloutStatusDeleted(t *testing.T) {
+	ro := &rollout{
+		controller:  podController{deployment: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "rollouts", Name: "deleted"}}},
+		restartedAt: testRestartedAt,
+	}
+
+	_, _, err := getRolloutStatus(context.Background(), ro)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected not found error for deleted Deployment, got %v", err)
+	}
+}
+
+func TestHasCrashingPods(t *testing.T) {
+	selector := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+	}
+
+	tests := []struct {
+		name         string
+		selector     *metav1.LabelSelector
+		expect       bool
+		expectReason string
+		expectErr    bool
+	}{
+		{"container crashing", selector("api"), true, "container app of pod api-1 is in CrashLoopBackOff", false},
+		{"init container crashing", selector("init"), true, "container init of pod init-1 is in CrashLoopBackOff", false},
+		{"pod from before the rollout", selector("worker"), false, "", false},
+		{"pods waiting or running", selector("web"), false, "", false},
+		{"no pods selected", selector("cache"), false, "", false},
+		{"invalid selector", &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Near"}},
+		}, false, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason, crashing, err := hasCrashingPods("crashing", test.selector, testRestartedAt)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("Expected error: %v, got %v", test.expectErr, err)
+			}
+
+			if crashing != test.expect {
+				t.Errorf("Expected crashing pods: %v, got %v", test.expect, crashing)
+			}
+			if reason != test.expectReason {
+				t.Errorf("Expected reason %q, got %q", test.expectReason, reason)
+			}
+		})
+	}
+}
+
+func TestRestartQueueIsHeld(t *testing.T) {
+	deployment := func(name string) podController {
+		return podController{deployment: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}}
+	}
+	changed := testSecret(map[string]string{"password": "hunter3"})
+	olderChange := testSecret(map[string]string{"password": "hunter2"})
+	unrelated := &managedResource{configMap: testConfigMap()}
+
+	watching := func(controller podController, resources ...*managedResource) *rollout {
+		ro := &rollout{controller: controller, resources: resources, changes: map[string]bool{}}
+		for _, version := range getChangeVersions(resources) {
+			ro.changes[version] = true
+		}
+		return ro
+	}
+
+	tests := []struct {
+		name     string
+		changed  []*managedResource
+		rollouts []*rollout
+		poisoned []*managedResource
+		expect   bool
+	}{
+		{"no rollouts", []*managedResource{changed}, nil, nil, false},
+		{"rollout of another pod controller for the same change", []*managedResource{changed}, []*rollout{watching(deployment("api"), changed)}, nil, true},
+		{"rollout of the same pod controller", []*managedResource{changed}, []*rollout{watching(deployment("web"), changed)}, nil, false},
+		{"rollout for an older change", []*managedResource{changed}, []*rollout{watching(deployment("api"), olderChange)}, nil, false},
+		{"rollout for an unrelated change", []*managedResource{changed}, []*rollout{watching(deployment("api"), unrelated)}, nil, false},
+		{"rollout for one of several changes", []*managedResource{unrelated, changed}, []*rollout{watching(deployment("api"), changed)}, nil, true},
+		{"no changes identified", nil, []*rollout{watching(deployment("api"), changed)}, nil, false},
+		{"poisoned change", []*managedResource{changed}, nil, []*managedResource{changed}, true},
+		{"older change poisoned", []*managedResource{changed}, nil, []*managedResource{olderChange}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := newRestartQueue()
+			for _, ro := range test.rollouts {
+				q.rollouts[ro.controller.getKey()] = ro
+			}
+			for _, version := range getChangeVersions(test.poisoned) {
+				q.poisoned[version] = true
+			}
+
+			r := &pendingRestart{controller: deployment("web"), changed: test.changed}
+			if held := q.isHeld(r); held != test.expect {
+				t.Errorf("Expected restart to be held: %v, got %v", test.expect, held)
+			}
+		})
+	}
+}
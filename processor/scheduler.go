@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/chongyangshi/Order/config"
+	"github.com/chongyangshi/Order/controllers"
+	"github.com/chongyangshi/Order/logging"
+	"github.com/chongyangshi/Order/metrics"
+	"github.com/chongyangshi/Order/proto"
+)
+
+// Run schedules the control loop to run once every controller resync duration set in
+// config, until the context is cancelled. It should be called after Init. In between
+// control loops, it also drains work queues of changed Secrets, ConfigMaps and custom
+// resources, so that pod controllers referencing them are restarted promptly. It only
+// returns once restarts and work items in progress are done.
+func Run(ctx context.Context) {
+	if config.Config == nil {
+		logging.Fatal("Error: processor unexpectedly started before config is parsed")
+	}
+
+	if clientSet == nil {
+		logging.Fatal("Error: processor unexpectedly started before it is initialised")
+	}
+
+	// Restarts pending from any previous run are discarded, as they will be queued
+	// again by reconciliation if still required.
+	restarts = newRestartQueue()
+
+	// Don't return until restarts being dispatched and work items being processed are
+	// done, so that another run can't overlap with this one.
+	work := &workInProgress{}
+	defer work.stop()
+	work.wg.Add(1)
+	go func() {
+		defer work.done()
+		restarts.run(ctx)
+	}()
+
+	secretsQueue, err := controllers.GetSecretsQueue()
+	if err != nil {
+		logging.Fatal("Error retrieving Secrets work queue: %v", err)
+	}
+	go runWorker(ctx, secretsQueue, proto.ManagedResourceTypeSecrets, work)
+
+	configMapsQueue, err := controllers.GetConfigMapsQueue()
+	if err != nil {
+		logging.Fatal("Error retrieving ConfigMaps work queue: %v", err)
+	}
+	go runWorker(ctx, configMapsQueue, proto.ManagedResourceTypeConfigMaps, work)
+
+	// Changed custom resources are told apart by their kinds
+	for kind, queue := range controllers.GetCustomResourcesQueues() {
+		go runWorker(ctx, queue, kind, work)
+	}
+
+	interval := config.Config.XXXControllerResyncDuration
+	logging.Log("Starting processor, running control loop every %v", interval)
+	if isDryRun() {
+		logging.Log("Processor is in dry run, pod controllers and managed resources will not be changed")
+	}
+	defer logging.Log("Shutting down processor.")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		startTime := time.Now()
+		err = controlLoop(ctx)
+		metrics.ObserveControlLoop(time.Since(startTime), err)
+		if err != nil {
+			logging.Log("Error running control loop: %v", err)
+		} else {
+			logging.Debug("Control loop completed in %v", time.Since(startTime))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
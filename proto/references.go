@@ -0,0 +1,39 @@
+package proto
+
+import "sort"
+
+// KeyReferences represents keys of a Secret or ConfigMap consumed by a pod controller.
+type KeyReferences struct {
+	// All is set if the pod controller consumes the resource as a whole, such as through
+	// envFrom or a volume mount without items, in which case any key may be consumed.
+	All bool
+
+	// Keys are consumed individually, such as through secretKeyRef or configMapKeyRef,
+	// or items of a volume mount.
+	Keys map[string]bool
+}
+
+// AllKeyReferences returns key references consuming a resource as a whole.
+func AllKeyReferences() *KeyReferences {
+	return &KeyReferences{All: true}
+}
+
+// AddKey records an individual key consumed.
+func (k *KeyReferences) AddKey(key string) {
+	if k.Keys == nil {
+		k.Keys = map[string]bool{}
+	}
+
+	k.Keys[key] = true
+}
+
+// SortedKeys returns individual keys consumed in a stable order.
+func (k *KeyReferences) SortedKeys() []string {
+	var keys []string
+	for key := range k.Keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -1,7 +1,12 @@
 package proto
 
 import (
+	"fmt"
+	"strings"
 	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 const (
@@ -12,22 +17,61 @@ const (
 	// Order last performed a rolling restart on a pod controller
 	LabelLastRollingRestart = "last-rolling-restart"
 
-	// LabelManagedResourcesHash is a SHA256 hash over all resources
-	// managed by Order on the target pod controller in order, in
-	// case the controller is falling very far behind due to prior
-	// errors, this helps us determine whether rolling restart is
-	// required on a pod controller
+	// LabelManagedResourcesHash is a hex encoded SHA256 hash over the
+	// content of all resources managed by Order on the target pod
+	// controller in order, in case the controller is falling very far
+	// behind due to prior errors, this helps us determine whether
+	// rolling restart is required on a pod controller
 	LabelManagedResourcesHash = "managed-resources-hash"
 
+	// LabelManagedResourceVersions is a JSON object recording hex encoded SHA256 hashes
+	// over the content of each managed resource consumed by a pod controller, by
+	// descriptions of the managed resources, as of its last restart. This allows Order
+	// to tell which managed resources have changed since.
+	LabelManagedResourceVersions = "managed-resource-versions"
+
+	// LabelWatch can be set by users on pod controllers to opt them into being managed
+	// by Order without central config, as a comma-separated list of managed resources in
+	// the same namespace, such as "secret/db-creds,configmap/app"
+	LabelWatch = "watch"
+
+	// LabelRestartByDeletion must be set to "true" by users on a Pod without
+	// any owner, to allow Order to restart it by deleting it
+	LabelRestartByDeletion = "restart-by-deletion"
+
+	// LabelPoisonedHash is set by Order on a managed resource whose change caused a
+	// rollout to fail, to the hex encoded SHA256 hash over its content at the time. Order
+	// won't restart pod controllers for the managed resource until its content changes,
+	// or until the annotation is removed.
+	LabelPoisonedHash = "poisoned-hash"
+
 	ManagedResourceTypeSecrets    = "Secrets"
 	ManagedResourceTypeConfigMaps = "ConfigMaps"
+	ManagedResourceTypeCustom     = "Custom"
 
-	PodControllerTypeDaemonSets   = "DaemonSet"
-	PodControllerTypeDeployments  = "Deployment"
-	PodControllerTypeJobs         = "Job"
-	PodControllerTypeStatefulSets = "StatefulSet"
+	PodControllerTypeDaemonSets             = "DaemonSet"
+	PodControllerTypeDeployments            = "Deployment"
+	PodControllerTypeJobs                   = "Job"
+	PodControllerTypeStatefulSets           = "StatefulSet"
+	PodControllerTypeReplicaSets            = "ReplicaSet"
+	PodControllerTypeReplicationControllers = "ReplicationController"
+	PodControllerTypePods                   = "Pod"
+	PodControllerTypeCronJobs               = "CronJob"
+
+	// JobPolicyIgnore leaves Jobs referencing a changed managed resource alone
+	JobPolicyIgnore = "ignore"
+	// JobPolicyRecreate deletes and re-creates Jobs from their spec, unless they have
+	// already finished
+	JobPolicyRecreate = "recreate"
+	// JobPolicyRecreateAll deletes and re-creates Jobs from their spec, re-running them
+	// if they have already finished
+	JobPolicyRecreateAll = "recreate-all"
+	// JobPolicyNotifyOnly reports Jobs which would need re-creating
+	JobPolicyNotifyOnly = "notify-only"
 
 	AllNamespaces = "*"
+
+	defaultMetricsAddress = ":9090"
 )
 
 // OrderConfig is a structue of system-wide and managed resource-specific
@@ -64,57 +108,204 @@ type OrderConfig struct {
 	PodControllerStagger          string `yaml:"pod_controller_stagger"`
 	XXXParsedPodControllerStagger time.Duration
 
-	// ManagedResources are Secrets and ConfigMaps, which when updated we want Order to
-	// perform automatic rolling restarts, subject to namespace and restart cooldown
-	// validation.
+	// RolloutDeadline is a Go duration which if set makes Order wait for the rollout of
+	// each pod controller it restarts to become healthy, before restarting other pod
+	// controllers for the same changes in managed resources. If the rollout does not
+	// become healthy within the deadline, those restarts stay on hold until it does, so
+	// that a breaking change does not propagate further. Only Deployments, StatefulSets
+	// and DaemonSets report rollout health, rollouts of other pod controllers are
+	// considered healthy immediately.
+	RolloutDeadline          string `yaml:"rollout_deadline"`
+	XXXParsedRolloutDeadline time.Duration
+
+	// RollbackOnFailure if set makes Order roll back a Deployment, StatefulSet or
+	// DaemonSet whose rollout fails while watched under rollout_deadline, by restoring
+	// the pod template annotation it had before Order restarted it. A rollout fails if
+	// a Deployment exceeds its progress deadline, or if any of its new pods is in
+	// CrashLoopBackOff. Regardless of this setting, managed resources whose changes the
+	// failed rollout was due to are marked as poisoned, and no further pod controllers
+	// are restarted for them.
+	RollbackOnFailure bool `yaml:"rollback_on_failure"`
+
+	// MaintenanceWindows if set restricts rolling restarts to when any of the windows is
+	// open. Restarts due outside the windows stay queued until the next window opens.
+	// Managed resources can set their own windows, which replace these.
+	MaintenanceWindows []*MaintenanceWindow `yaml:"maintenance_windows"`
+
+	// Blackouts are periods during which no rolling restarts are performed, even within
+	// maintenance windows, such as change freezes over holidays. Restarts due during a
+	// blackout stay queued until it ends. Blackouts of managed resources apply on top
+	// of these.
+	Blackouts []*Blackout `yaml:"blackouts"`
+
+	// ManagedResources are Secrets, ConfigMaps and custom resources, which when updated
+	// we want Order to perform automatic rolling restarts, subject to namespace and
+	// restart cooldown validation.
 	ManagedResources []*ManagedResource `yaml:"managed_resources"`
 
+	// AutoDiscover if set treats every Secret and ConfigMap referenced by the pod template
+	// of a pod controller as managed by Order, without them needing to be listed in
+	// managed_resources, using system-wide default cooldown and stagger.
+	AutoDiscover bool `yaml:"auto_discover"`
+
+	// AutoDiscoverNamespaces enables auto_discover above only for pod controllers in
+	// these namespaces, if auto_discover itself is not set.
+	AutoDiscoverNamespaces []string `yaml:"auto_discover_namespaces"`
+
+	// HashIgnoreMetadata if set excludes labels and annotations of managed resources from
+	// the content we hash to determine whether they have changed, so that metadata-only
+	// updates don't cause rolling restarts. Annotations managed by Order, as well as
+	// kubectl's last-applied-configuration, are always excluded.
+	HashIgnoreMetadata bool `yaml:"hash_ignore_metadata"`
+
+	// CustomPodControllers are kinds of custom resources which embed a pod template and
+	// roll out their pods when it changes, such as Argo Rollouts. Order restarts them in
+	// the same way as built-in pod controllers.
+	CustomPodControllers []*CustomPodController `yaml:"custom_pod_controllers"`
+
+	// DryRun if set makes Order work out which pod controllers it would restart and
+	// report them through logs, Events and metrics, without making any changes to pod
+	// controllers or managed resources. It can be overridden with environment variable
+	// ORDER_DRY_RUN.
+	DryRun bool `yaml:"dry_run"`
+
+	// LeaderElection if set makes replicas of Order elect a leader through a Lease, and
+	// only the leader restarts pod controllers. Other replicas keep their caches warm to
+	// take over promptly. The Lease is created in leader_election_namespace, or otherwise
+	// the namespace in environment variable POD_NAMESPACE, or otherwise kube-system.
+	LeaderElection          bool   `yaml:"leader_election"`
+	LeaderElectionNamespace string `yaml:"leader_election_namespace"`
+
+	// MetricsAddress is the address on which Prometheus metrics are served at /metrics,
+	// by default :9090.
+	MetricsAddress string `yaml:"metrics_address"`
+
 	// DebugOutput controls whether we print debug messages to stdout at debug level
 	DebugOutput bool `yaml:"debug_output"`
 }
 
+// CustomPodController represents a kind of custom resource embedding a pod template,
+// which Order can restart by updating annotations of the pod template.
+type CustomPodController struct {
+	// Group, Version and Kind of the custom resource, such as argoproj.io, v1alpha1
+	// and Rollout. Kind is also the pod controller type used to refer to custom
+	// resources of this kind in whitelisted_controllers and blacklisted_controllers.
+	Group   string `yaml:"group"`
+	Version string `yaml:"version"`
+	Kind    string `yaml:"kind"`
+
+	// PodTemplatePath is a JSONPath expression locating the pod template in the custom
+	// resource, such as .spec.template. Only plain field names are supported, as the
+	// pod template must be patched at the same path, which can't be under metadata.
+	PodTemplatePath          string `yaml:"pod_template_path"`
+	XXXParsedPodTemplatePath []string
+}
+
 // ManagedResource represents a mountable or referenceable resource whose changes are
 // monitored by Order.
 type ManagedResource struct {
 	// Type is a Kubernetes resource type for the managed resource, currently either Secrets
-	// or ConfigMaps.
+	// or ConfigMaps, or Custom for custom resources of the group, version and kind below.
 	Type string `yaml:"type"`
 
-	// Name of the managed resource
+	// Group, Version and Kind of a Custom managed resource, such as cert-manager.io, v1
+	// and Certificate.
+	Group   string `yaml:"group"`
+	Version string `yaml:"version"`
+	Kind    string `yaml:"kind"`
+
+	// HashField is a JSONPath expression of plain field names locating the field of a
+	// Custom managed resource whose changes should restart pod controllers, such as
+	// .status.revision. If not set, changes to metadata.generation restart them.
+	HashField          string `yaml:"hash_field"`
+	XXXParsedHashField []string
+
+	// PodControllers nominates pod controllers to restart when a Custom managed resource
+	// changes, as pod templates can't reference custom resources directly. Pod
+	// controllers referencing Secrets owned by the custom resource are also restarted
+	// without being nominated.
+	PodControllers []*PodControllerReference `yaml:"pod_controllers"`
+
+	// Name of the managed resource, which can also be a shell-style glob pattern such
+	// as tls-*, matching all resources of the type whose names match the pattern. It
+	// can be omitted if selector is set, in which case all names are matched.
 	Name string `yaml:"name"`
 
-	// Namespace of the managed resource
+	// Selector is an optional Kubernetes label selector such as
+	// order.kube-system.com/managed=true. If set, only resources matching both the
+	// selector and the name and namespace patterns are managed.
+	Selector          string `yaml:"selector"`
+	XXXParsedSelector labels.Selector
+
+	// Namespace of the managed resource, which can also be "*" for all namespaces, or a
+	// shell-style glob pattern such as tenant-*.
 	Namespace string `yaml:"namespace"`
 
+	// RestartCooldown is a Go duration which if set overrides default_restart_cooldown
+	// for pod controllers referencing this managed resource. Where a pod controller
+	// references multiple managed resources, the shortest cooldown among them applies,
+	// so that no managed resource waits longer than it asks to. This value cannot be
+	// below 30s.
+	RestartCooldown          string `yaml:"restart_cooldown"`
+	XXXParsedRestartCooldown time.Duration
+
+	// PodControllerStagger is a Go duration which if set overrides pod_controller_stagger
+	// after restarting pod controllers referencing this managed resource. Where a pod
+	// controller references multiple managed resources, the longest stagger among them
+	// applies. This value cannot be below 5s.
+	PodControllerStagger          string `yaml:"pod_controller_stagger"`
+	XXXParsedPodControllerStagger time.Duration
+
+	// TerminateRunningJobs if set makes Order delete Jobs still running, which were
+	// spawned by CronJobs referencing this managed resource before it changed. By
+	// default, only future Jobs spawned by the CronJobs pick up the change.
+	TerminateRunningJobs bool `yaml:"terminate_running_jobs"`
+
+	// JobPolicy determines what Order does with standalone Jobs referencing this managed
+	// resource when it changes, as Job pod templates are immutable and can't be rolling
+	// restarted. One of ignore (default), recreate, which deletes and re-creates the Job
+	// from its spec if it has not yet finished, recreate-all, which also re-runs Jobs
+	// which have completed or failed, or notify-only, which reports the Job as out of
+	// date. Jobs spawned by CronJobs are handled through their CronJobs instead.
+	JobPolicy string `yaml:"job_policy"`
+
+	// MaintenanceWindows if set replaces system-wide maintenance_windows for restarts of
+	// pod controllers referencing this managed resource. Where a pod controller
+	// references multiple managed resources, it is only restarted while windows of all
+	// of them are open.
+	MaintenanceWindows []*MaintenanceWindow `yaml:"maintenance_windows"`
+
+	// Blackouts are periods during which pod controllers referencing this managed
+	// resource are not restarted, in addition to system-wide blackouts.
+	Blackouts []*Blackout `yaml:"blackouts"`
+
 	// WhitelistedControllers if not empty will restrict pod controllers to be restarted
 	// to those matching this list only. It takes precedence over blacklisted_controllers
 	// below
-	WhitelistedControllers []struct {
-		// Name of the nominated pod controller
-		Name string `yaml:"name"`
-
-		// Namespace of the nominated pod controller
-		Namespace string `yaml:"namespace"`
-
-		// Type of the nominated pod controller, one of DaemonSets, Deployments, Jobs, or
-		// StatefulSets.
-		Type string `yaml:"type"`
-	} `yaml:"whitelisted_controllers"`
+	WhitelistedControllers []*PodControllerReference `yaml:"whitelisted_controllers"`
 
 	// BlacklistedControllers prevent pod controllers which would otherwise be restarted
 	// due to change in a managed resource they mount from being restarted by Order. It
 	// is ineffective if whitelisted_controllers is set above.
-	BlacklistedControllers []struct {
-		// Name of the nominated pod controller
-		Name string `yaml:"name"`
+	BlacklistedControllers []*PodControllerReference `yaml:"blacklisted_controllers"`
+}
+
+// PodControllerReference nominates a pod controller in whitelisted_controllers or
+// blacklisted_controllers of a managed resource.
+type PodControllerReference struct {
+	// Name of the nominated pod controller
+	Name string `yaml:"name"`
 
-		// Namespace of the nominated pod controller
-		Namespace string `yaml:"namespace"`
+	// Namespace of the nominated pod controller. If not set, the namespace of the
+	// managed resource is assumed, as pod controllers can only reference managed
+	// resources in their own namespace. This is useful when the managed resource's
+	// namespace is a pattern.
+	Namespace string `yaml:"namespace"`
 
-		// Type of the nominated pod controller, one of DaemonSets, Deployments, Jobs, or
-		// StatefulSets.
-		Type string `yaml:"type"`
-	} `yaml:"blacklisted_controllers"`
+	// Type of the nominated pod controller, one of DaemonSet, Deployment, Job,
+	// StatefulSet, ReplicaSet, ReplicationController, Pod or CronJob, or the kind of
+	// a custom pod controller.
+	Type string `yaml:"type"`
 }
 
 // Parse populates parsed fields of the config which are derived from YAML values.
@@ -145,5 +336,326 @@ func (c *OrderConfig) Parse() error {
 	}
 	c.XXXParsedPodControllerStagger = *podControllerStagger
 
+	// Parse rollout deadline, which is not set by default
+	rolloutDeadline, err := getRolloutDeadline(c.RolloutDeadline)
+	if err != nil {
+		return err
+	}
+	c.XXXParsedRolloutDeadline = rolloutDeadline
+
+	err = parseMaintenanceWindows(c.MaintenanceWindows)
+	if err != nil {
+		return err
+	}
+
+	err = parseBlackouts(c.Blackouts)
+	if err != nil {
+		return err
+	}
+
+	if c.MetricsAddress == "" {
+		c.MetricsAddress = defaultMetricsAddress
+	}
+
+	// Parse custom pod controllers, which managed resources may nominate below
+	seenKinds := map[string]bool{}
+	for _, controller := range c.CustomPodControllers {
+		if controller == nil {
+			continue
+		}
+
+		err = controller.parse()
+		if err != nil {
+			return fmt.Errorf("Error parsing custom pod controller %s of %s/%s: %v", controller.Kind, controller.Group, controller.Version, err)
+		}
+
+		if seenKinds[controller.Kind] {
+			return fmt.Errorf("Custom pod controller kind %s is configured more than once", controller.Kind)
+		}
+		seenKinds[controller.Kind] = true
+	}
+
+	// Parse managed resources, resolving their overrides against the defaults above
+	for _, resource := range c.ManagedResources {
+		if resource == nil {
+			continue
+		}
+
+		err = resource.parse(c)
+		if err != nil {
+			return fmt.Errorf("Error parsing managed resource %s %s of namespace %s: %v", resource.Type, resource.Name, resource.Namespace, err)
+		}
+	}
+
+	// Custom managed resources are told apart by kind when they change, so the same kind
+	// can't be used across groups or versions.
+	customKinds := map[string]schema.GroupVersionKind{}
+	for _, gvk := range c.CustomManagedResourceKinds() {
+		if existing, found := customKinds[gvk.Kind]; found {
+			return fmt.Errorf("Custom managed resource kind %s is configured as both %s and %s", gvk.Kind, existing.String(), gvk.String())
+		}
+		customKinds[gvk.Kind] = gvk
+	}
+
+	return nil
+}
+
+// parse populates parsed fields of a managed resource, falling back to system-wide
+// defaults of the config for fields not set.
+func (r *ManagedResource) parse(c *OrderConfig) error {
+	if !validateManagedResourceType(r.Type) {
+		return fmt.Errorf("Invalid managed resource type %s", r.Type)
+	}
+
+	if r.Type == ManagedResourceTypeCustom {
+		if r.Version == "" || r.Kind == "" {
+			return fmt.Errorf("Custom managed resources must have version and kind set")
+		}
+
+		if r.HashField != "" {
+			hashField, err := parseFieldPath(r.HashField)
+			if err != nil {
+				return fmt.Errorf("Invalid hash field: %v", err)
+			}
+			r.XXXParsedHashField = hashField
+		}
+
+		for _, controller := range r.PodControllers {
+			err := controller.validate(c)
+			if err != nil {
+				return fmt.Errorf("Invalid pod controller: %v", err)
+			}
+		}
+	} else if len(r.PodControllers) > 0 {
+		return fmt.Errorf("Pod controllers can only be nominated for Custom managed resources")
+	}
+
+	if r.Selector != "" {
+		selector, err := labels.Parse(r.Selector)
+		if err != nil {
+			return fmt.Errorf("Invalid managed resource selector %s: %v", r.Selector, err)
+		}
+		r.XXXParsedSelector = selector
+
+		// With a selector, name becomes optional and matches everything if not set
+		if r.Name == "" {
+			r.Name = "*"
+		}
+	}
+
+	if r.JobPolicy == "" {
+		r.JobPolicy = JobPolicyIgnore
+	}
+
+	if !validateJobPolicy(r.JobPolicy) {
+		return fmt.Errorf("Invalid job policy %s", r.JobPolicy)
+	}
+
+	if !validateNamePattern(r.Name) {
+		return fmt.Errorf("Invalid managed resource name pattern %s", r.Name)
+	}
+
+	if !validateNamePattern(r.Namespace) {
+		return fmt.Errorf("Invalid managed resource namespace pattern %s", r.Namespace)
+	}
+
+	for _, controller := range r.WhitelistedControllers {
+		err := controller.validate(c)
+		if err != nil {
+			return fmt.Errorf("Invalid whitelisted controller: %v", err)
+		}
+	}
+
+	for _, controller := range r.BlacklistedControllers {
+		err := controller.validate(c)
+		if err != nil {
+			return fmt.Errorf("Invalid blacklisted controller: %v", err)
+		}
+	}
+
+	r.XXXParsedRestartCooldown = c.XXXParsedRestartCooldown
+	if r.RestartCooldown != "" {
+		restartCooldown, err := getRestartCooldownPeriod(r.RestartCooldown)
+		if err != nil {
+			return err
+		}
+		r.XXXParsedRestartCooldown = *restartCooldown
+	}
+
+	r.XXXParsedPodControllerStagger = c.XXXParsedPodControllerStagger
+	if r.PodControllerStagger != "" {
+		podControllerStagger, err := getPodControllerStaggerPeriod(r.PodControllerStagger)
+		if err != nil {
+			return err
+		}
+		r.XXXParsedPodControllerStagger = *podControllerStagger
+	}
+
+	err := parseMaintenanceWindows(r.MaintenanceWindows)
+	if err != nil {
+		return err
+	}
+
+	return parseBlackouts(r.Blackouts)
+}
+
+// parse validates a custom pod controller and populates its parsed pod template path.
+func (c *CustomPodController) parse() error {
+	if c.Version == "" || c.Kind == "" {
+		return fmt.Errorf("version and kind must be set")
+	}
+
+	if validatePodControllerType(c.Kind) {
+		return fmt.Errorf("kind %s clashes with a built-in pod controller type", c.Kind)
+	}
+
+	podTemplatePath, err := parseFieldPath(c.PodTemplatePath)
+	if err != nil {
+		return fmt.Errorf("Invalid pod template path: %v", err)
+	}
+
+	// Order records its own annotations in metadata of the custom pod controller, which
+	// the pod template patch must not replace
+	if podTemplatePath[0] == "metadata" {
+		return fmt.Errorf("Invalid pod template path %s, the pod template can't be under metadata", c.PodTemplatePath)
+	}
+	c.XXXParsedPodTemplatePath = podTemplatePath
+
+	return nil
+}
+
+// GroupVersionKind returns the group, version and kind of the custom pod controller.
+func (c *CustomPodController) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: c.Group, Version: c.Version, Kind: c.Kind}
+}
+
+// GetCustomPodController returns the custom pod controller of the kind, or nil if it
+// is not configured.
+func (c *OrderConfig) GetCustomPodController(kind string) *CustomPodController {
+	for _, controller := range c.CustomPodControllers {
+		if controller != nil && controller.Kind == kind {
+			return controller
+		}
+	}
+
 	return nil
 }
+
+// validate checks that a nominated pod controller is fully specified.
+func (r *PodControllerReference) validate(c *OrderConfig) error {
+	if r == nil {
+		return fmt.Errorf("empty pod controller entry")
+	}
+
+	if r.Name == "" {
+		return fmt.Errorf("pod controller of type %s in namespace %s has no name", r.Type, r.Namespace)
+	}
+
+	if !validatePodControllerType(r.Type) && c.GetCustomPodController(r.Type) == nil {
+		return fmt.Errorf("pod controller %s in namespace %s has unsupported type %s", r.Name, r.Namespace, r.Type)
+	}
+
+	return nil
+}
+
+// Matches returns whether the nominated pod controller is the pod controller given,
+// assuming the default namespace if the nominated pod controller has no namespace.
+func (r *PodControllerReference) Matches(controllerType, namespace, name, defaultNamespace string) bool {
+	if r == nil {
+		return false
+	}
+
+	nominatedNamespace := r.Namespace
+	if nominatedNamespace == "" {
+		nominatedNamespace = defaultNamespace
+	}
+
+	return r.Type == controllerType && nominatedNamespace == namespace && r.Name == name
+}
+
+// GroupVersionKind returns the group, version and kind of a Custom managed resource.
+func (r *ManagedResource) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: r.Group, Version: r.Version, Kind: r.Kind}
+}
+
+// CustomManagedResourceKinds returns the distinct group, version and kinds of Custom
+// managed resources in config, which need to be watched.
+func (c *OrderConfig) CustomManagedResourceKinds() []schema.GroupVersionKind {
+	var kinds []schema.GroupVersionKind
+	seen := map[schema.GroupVersionKind]bool{}
+	for _, resource := range c.ManagedResources {
+		if resource == nil || resource.Type != ManagedResourceTypeCustom {
+			continue
+		}
+
+		gvk := resource.GroupVersionKind()
+		if seen[gvk] {
+			continue
+		}
+		seen[gvk] = true
+		kinds = append(kinds, gvk)
+	}
+
+	return kinds
+}
+
+// ParseWatchAnnotation parses the value of a LabelWatch annotation set on a pod controller
+// in the given namespace, returning managed resources it opts into, with cooldown and
+// stagger of the config's system-wide defaults.
+func (c *OrderConfig) ParseWatchAnnotation(value, namespace string) ([]*ManagedResource, error) {
+	var resources []*ManagedResource
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, "/")
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid entry %s, expecting secret/<name> or configmap/<name>", entry)
+		}
+
+		var resourceType string
+		switch strings.ToLower(parts[0]) {
+		case "secret", "secrets":
+			resourceType = ManagedResourceTypeSecrets
+		case "configmap", "configmaps":
+			resourceType = ManagedResourceTypeConfigMaps
+		default:
+			return nil, fmt.Errorf("Invalid entry %s, unsupported type %s", entry, parts[0])
+		}
+
+		resources = append(resources, c.NewDefaultManagedResource(resourceType, parts[1], namespace))
+	}
+
+	return resources, nil
+}
+
+// NewDefaultManagedResource returns a managed resource not listed in config, which uses
+// system-wide default cooldown and stagger of the config.
+func (c *OrderConfig) NewDefaultManagedResource(resourceType, name, namespace string) *ManagedResource {
+	return &ManagedResource{
+		Type:                          resourceType,
+		Name:                          name,
+		Namespace:                     namespace,
+		JobPolicy:                     JobPolicyIgnore,
+		XXXParsedRestartCooldown:      c.XXXParsedRestartCooldown,
+		XXXParsedPodControllerStagger: c.XXXParsedPodControllerStagger,
+	}
+}
+
+// AutoDiscoverEnabled returns whether auto discovery of managed resources is enabled
+// for pod controllers in the namespace.
+func (c *OrderConfig) AutoDiscoverEnabled(namespace string) bool {
+	if c.AutoDiscover {
+		return true
+	}
+
+	for _, ns := range c.AutoDiscoverNamespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,6 +2,8 @@ package proto
 
 import (
 	"fmt"
+	"path"
+	"strings"
 	"time"
 )
 
@@ -13,25 +15,87 @@ const (
 
 func validateManagedResourceType(t string) bool {
 	switch t {
-	case ManagedResourceTypeConfigMaps, ManagedResourceTypeSecrets:
+	case ManagedResourceTypeConfigMaps, ManagedResourceTypeSecrets, ManagedResourceTypeCustom:
 		return true
 	}
 
 	return false
 }
 
+// validateNamePattern checks whether a managed resource name or namespace is a valid
+// shell-style glob pattern, which includes plain names and AllNamespaces.
+func validateNamePattern(p string) bool {
+	if p == "" {
+		return false
+	}
+
+	_, err := path.Match(p, "")
+	return err == nil
+}
+
+// MatchesNamePattern returns whether a name or namespace matches a shell-style glob
+// pattern validated by validateNamePattern, such as "tls-*".
+func MatchesNamePattern(pattern, name string) bool {
+	if pattern == AllNamespaces {
+		return true
+	}
+
+	matched, err := path.Match(pattern, name)
+	if err != nil {
+		return false
+	}
+
+	return matched
+}
+
 func validatePodControllerType(t string) bool {
 	switch t {
 	case PodControllerTypeDaemonSets,
 		PodControllerTypeDeployments,
 		PodControllerTypeJobs,
-		PodControllerTypeStatefulSets:
+		PodControllerTypeStatefulSets,
+		PodControllerTypeReplicaSets,
+		PodControllerTypeReplicationControllers,
+		PodControllerTypePods,
+		PodControllerTypeCronJobs:
+		return true
+	}
+
+	return false
+}
+
+func validateJobPolicy(p string) bool {
+	switch p {
+	case JobPolicyIgnore,
+		JobPolicyRecreate,
+		JobPolicyRecreateAll,
+		JobPolicyNotifyOnly:
 		return true
 	}
 
 	return false
 }
 
+// parseFieldPath parses a JSONPath expression of plain field names, such as
+// .spec.template or {.spec.template}, into the field names.
+func parseFieldPath(p string) ([]string, error) {
+	trimmed := strings.TrimSpace(p)
+	trimmed = strings.TrimSuffix(strings.TrimPrefix(trimmed, "{"), "}")
+	trimmed = strings.TrimPrefix(trimmed, ".")
+	if trimmed == "" {
+		return nil, fmt.Errorf("Field path must be set")
+	}
+
+	fields := strings.Split(trimmed, ".")
+	for _, field := range fields {
+		if field == "" || strings.ContainsAny(field, "[]*@$?()'\" ") {
+			return nil, fmt.Errorf("Invalid field path %s, only plain field names are supported", p)
+		}
+	}
+
+	return fields, nil
+}
+
 func getControllerResyncPeriod(d string) (*time.Duration, error) {
 	if d == "" {
 		defaultPeriod := controllerResyncSafetyLowerBound
@@ -85,3 +149,21 @@ func getPodControllerStaggerPeriod(d string) (*time.Duration, error) {
 
 	return &t, nil
 }
+
+// getRolloutDeadline parses the rollout deadline, which is zero if not set.
+func getRolloutDeadline(d string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+
+	t, err := time.ParseDuration(d)
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing rollout deadline %s", d)
+	}
+
+	if t <= 0 {
+		return 0, fmt.Errorf("Specified rollout deadline %s is not positive", d)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,229 @@
+package proto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// blackoutDateLayout is accepted for blackout start and end besides RFC3339, in which
+	// case the blackout covers the whole day of the end date.
+	blackoutDateLayout = "2006-01-02"
+
+	// restartTimeSearchLimit bounds how many windows and blackouts are stepped through
+	// when working out the next time restarts are allowed.
+	restartTimeSearchLimit = 1000
+)
+
+// MaintenanceWindow is a recurring window during which Order may restart pod controllers.
+type MaintenanceWindow struct {
+	// Schedule is a standard cron expression of five fields, such as "0 22 * * 1-5",
+	// for when the window opens.
+	Schedule          string `yaml:"schedule"`
+	XXXParsedSchedule cron.Schedule
+
+	// Duration is a Go duration for how long the window stays open.
+	Duration          string `yaml:"duration"`
+	XXXParsedDuration time.Duration
+
+	// TimeZone is an IANA time zone such as Europe/London, in which the schedule is
+	// interpreted. If not set, UTC is assumed.
+	TimeZone string `yaml:"time_zone"`
+}
+
+// Blackout is a period during which Order must not restart pod controllers, even within
+// maintenance windows.
+type Blackout struct {
+	// Start and End of the blackout, as either RFC3339 timestamps or dates such as
+	// 2025-12-24. A date as end includes the whole day.
+	Start          string `yaml:"start"`
+	XXXParsedStart time.Time
+	End            string `yaml:"end"`
+	XXXParsedEnd   time.Time
+
+	// TimeZone is an IANA time zone such as Europe/London, in which dates are
+	// interpreted. If not set, UTC is assumed.
+	TimeZone string `yaml:"time_zone"`
+}
+
+// parse validates a maintenance window and populates its parsed schedule and duration.
+func (w *MaintenanceWindow) parse() error {
+	if w == nil {
+		return fmt.Errorf("empty maintenance window entry")
+	}
+
+	location, err := loadTimeZone(w.TimeZone)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := cron.ParseStandard(w.Schedule)
+	if err != nil {
+		return fmt.Errorf("Invalid maintenance window schedule %s: %v", w.Schedule, err)
+	}
+	if specSchedule, ok := schedule.(*cron.SpecSchedule); ok {
+		specSchedule.Location = location
+	}
+	w.XXXParsedSchedule = schedule
+
+	duration, err := time.ParseDuration(w.Duration)
+	if err != nil {
+		return fmt.Errorf("Error parsing maintenance window duration %s", w.Duration)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("Specified maintenance window duration %s is not positive", w.Duration)
+	}
+	w.XXXParsedDuration = duration
+
+	return nil
+}
+
+// IsOpen returns whether the maintenance window is open at the time.
+func (w *MaintenanceWindow) IsOpen(t time.Time) bool {
+	// The latest opening at or before t is the first one after t minus duration, if any.
+	opening := w.XXXParsedSchedule.Next(t.Add(-w.XXXParsedDuration))
+	return !opening.IsZero() && !opening.After(t)
+}
+
+// parse validates a blackout and populates its parsed start and end.
+func (b *Blackout) parse() error {
+	if b == nil {
+		return fmt.Errorf("empty blackout entry")
+	}
+
+	location, err := loadTimeZone(b.TimeZone)
+	if err != nil {
+		return err
+	}
+
+	b.XXXParsedStart, err = parseBlackoutTime(b.Start, location, false)
+	if err != nil {
+		return err
+	}
+
+	b.XXXParsedEnd, err = parseBlackoutTime(b.End, location, true)
+	if err != nil {
+		return err
+	}
+
+	if !b.XXXParsedEnd.After(b.XXXParsedStart) {
+		return fmt.Errorf("Blackout end %s is not after its start %s", b.End, b.Start)
+	}
+
+	return nil
+}
+
+// IsActive returns whether the blackout is in effect at the time.
+func (b *Blackout) IsActive(t time.Time) bool {
+	return !t.Before(b.XXXParsedStart) && t.Before(b.XXXParsedEnd)
+}
+
+// NextRestartTime returns the earliest time at or after t when restarts are allowed, and
+// whether there is such a time. Restarts are allowed while, for each set of maintenance
+// windows, any window of the set is open, and no blackout is in effect. An empty set of
+// windows allows restarts at any time.
+func NextRestartTime(t time.Time, windowSets [][]*MaintenanceWindow, blackouts []*Blackout) (time.Time, bool) {
+	for i := 0; i < restartTimeSearchLimit; i++ {
+		next := t
+		for _, b := range blackouts {
+			if b.IsActive(next) {
+				next = b.XXXParsedEnd
+			}
+		}
+
+		for _, windows := range windowSets {
+			if opening, found := nextWindowOpening(next, windows); !found {
+				return time.Time{}, false
+			} else if opening.After(next) {
+				next = opening
+			}
+		}
+
+		if next.Equal(t) {
+			return t, true
+		}
+		t = next
+	}
+
+	return time.Time{}, false
+}
+
+// nextWindowOpening returns the earliest time at or after t when any of the maintenance
+// windows is open, and whether there is such a time.
+func nextWindowOpening(t time.Time, windows []*MaintenanceWindow) (time.Time, bool) {
+	if len(windows) == 0 {
+		return t, true
+	}
+
+	var earliest time.Time
+	for _, w := range windows {
+		if w.IsOpen(t) {
+			return t, true
+		}
+
+		opening := w.XXXParsedSchedule.Next(t)
+		if !opening.IsZero() && (earliest.IsZero() || opening.Before(earliest)) {
+			earliest = opening
+		}
+	}
+
+	return earliest, !earliest.IsZero()
+}
+
+// parseMaintenanceWindows parses each of the maintenance windows.
+func parseMaintenanceWindows(windows []*MaintenanceWindow) error {
+	for _, w := range windows {
+		err := w.parse()
+		if err != nil {
+			return fmt.Errorf("Invalid maintenance window: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// parseBlackouts parses each of the blackouts.
+func parseBlackouts(blackouts []*Blackout) error {
+	for _, b := range blackouts {
+		err := b.parse()
+		if err != nil {
+			return fmt.Errorf("Invalid blackout: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func loadTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time zone %s: %v", name, err)
+	}
+
+	return location, nil
+}
+
+// parseBlackoutTime parses the start or end of a blackout. A date as end is taken as
+// the start of the following day, so that the whole day is covered.
+func parseBlackoutTime(value string, location *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(blackoutDateLayout, value, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Error parsing blackout time %s, expecting RFC3339 or %s", value, blackoutDateLayout)
+	}
+
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
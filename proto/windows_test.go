@@ -0,0 +1,213 @@
+package proto
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("Error parsing time %s: %v", value, err)
+	}
+
+	return parsed
+}
+
+func mustParseWindow(t *testing.T, schedule, duration, timeZone string) *MaintenanceWindow {
+	t.Helper()
+
+	w := &MaintenanceWindow{Schedule: schedule, Duration: duration, TimeZone: timeZone}
+	if err := w.parse(); err != nil {
+		t.Fatalf("Error parsing maintenance window %s for %s in %s: %v", schedule, duration, timeZone, err)
+	}
+
+	return w
+}
+
+func mustParseBlackout(t *testing.T, start, end, timeZone string) *Blackout {
+	t.Helper()
+
+	b := &Blackout{Start: start, End: end, TimeZone: timeZone}
+	if err := b.parse(); err != nil {
+		t.Fatalf("Error parsing blackout from %s to %s in %s: %v", start, end, timeZone, err)
+	}
+
+	return b
+}
+
+func TestMaintenanceWindowParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   *MaintenanceWindow
+		expectOK bool
+	}{
+		{"valid", &MaintenanceWindow{Schedule: "0 22 * * 1-5", Duration: "4h"}, true},
+		{"valid with time zone", &MaintenanceWindow{Schedule: "0 22 * * *", Duration: "30m", TimeZone: "Europe/London"}, true},
+		{"invalid schedule", &MaintenanceWindow{Schedule: "0 25 * * *", Duration: "1h"}, false},
+		{"seconds field not accepted", &MaintenanceWindow{Schedule: "0 0 22 * * *", Duration: "1h"}, false},
+		{"missing duration", &MaintenanceWindow{Schedule: "0 22 * * *"}, false},
+		{"zero duration", &MaintenanceWindow{Schedule: "0 22 * * *", Duration: "0s"}, false},
+		{"negative duration", &MaintenanceWindow{Schedule: "0 22 * * *", Duration: "-1h"}, false},
+		{"invalid time zone", &MaintenanceWindow{Schedule: "0 22 * * *", Duration: "1h", TimeZone: "Mars/Olympus"}, false},
+		{"nil", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.window.parse()
+			if ok := err == nil; ok != test.expectOK {
+				t.Errorf("Expected parse to succeed: %v, got error %v", test.expectOK, err)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowIsOpen(t *testing.T) {
+	// Opens at 22:00 in London for four hours, which is 21:00 UTC in summer time
+	london := mustParseWindow(t, "0 22 * * *", "4h", "Europe/London")
+	// Opens at 09:00 UTC on weekdays for an hour
+	weekdays := mustParseWindow(t, "0 9 * * 1-5", "1h", "")
+
+	tests := []struct {
+		name   string
+		window *MaintenanceWindow
+		at     string
+		expect bool
+	}{
+		{"before opening in summer time", london, "2026-07-01T20:59:59Z", false},
+		{"at opening in summer time", london, "2026-07-01T21:00:00Z", true},
+		{"open across midnight", london, "2026-07-02T00:30:00Z", true},
+		{"just before closing", london, "2026-07-02T00:59:59Z", true},
+		{"at closing", london, "2026-07-02T01:00:00Z", false},
+		{"at opening in winter time", london, "2026-01-15T22:00:00Z", true},
+		{"summer time offset not applied in winter", london, "2026-01-15T21:30:00Z", false},
+		{"weekday at opening", weekdays, "2026-10-16T09:00:00Z", true},
+		{"weekday after closing", weekdays, "2026-10-16T10:00:00Z", false},
+		{"weekend", weekdays, "2026-10-17T09:30:00Z", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if open := test.window.IsOpen(mustParseTime(t, test.at)); open != test.expect {
+				t.Errorf("Expected window open at %s: %v, got %v", test.at, test.expect, open)
+			}
+		})
+	}
+}
+
+func TestBlackoutParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		start, end  string
+		timeZone    string
+		expectStart string
+		expectEnd   string
+		expectOK    bool
+	}{
+		{"dates cover the whole end date", "2026-12-24", "2026-12-26", "", "2026-12-24T00:00:00Z", "2026-12-27T00:00:00Z", true},
+		{"dates in time zone", "2026-12-24", "2026-12-24", "America/New_York", "2026-12-24T05:00:00Z", "2026-12-25T05:00:00Z", true},
+		{"timestamps", "2026-12-24T18:00:00+01:00", "2026-12-25T06:00:00Z", "", "2026-12-24T17:00:00Z", "2026-12-25T06:00:00Z", true},
+		{"timestamps ignore time zone", "2026-12-24T18:00:00Z", "2026-12-24T19:00:00Z", "Asia/Tokyo", "2026-12-24T18:00:00Z", "2026-12-24T19:00:00Z", true},
+		{"end before start", "2026-12-26", "2026-12-24", "", "", "", false},
+		{"empty period", "2026-12-24T18:00:00Z", "2026-12-24T18:00:00Z", "", "", "", false},
+		{"invalid start", "24/12/2026", "2026-12-26", "", "", "", false},
+		{"missing end", "2026-12-24", "", "", "", "", false},
+		{"invalid time zone", "2026-12-24", "2026-12-26", "Mars/Olympus", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &Blackout{Start: test.start, End: test.end, TimeZone: test.timeZone}
+			err := b.parse()
+			if ok := err == nil; ok != test.expectOK {
+				t.Fatalf("Expected parse to succeed: %v, got error %v", test.expectOK, err)
+			}
+			if !test.expectOK {
+				return
+			}
+
+			if expected := mustParseTime(t, test.expectStart); !b.XXXParsedStart.Equal(expected) {
+				t.Errorf("Expected start %v, got %v", expected, b.XXXParsedStart)
+			}
+			if expected := mustParseTime(t, test.expectEnd); !b.XXXParsedEnd.Equal(expected) {
+				t.Errorf("Expected end %v, got %v", expected, b.XXXParsedEnd)
+			}
+		})
+	}
+}
+
+func TestBlackoutIsActive(t *testing.T) {
+	b := mustParseBlackout(t, "2026-12-24", "2026-12-26", "Europe/London")
+
+	tests := []struct {
+		at     string
+		expect bool
+	}{
+		{"2026-12-23T23:59:59Z", false},
+		{"2026-12-24T00:00:00Z", true},
+		{"2026-12-26T23:59:59Z", true},
+		{"2026-12-27T00:00:00Z", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.at, func(t *testing.T) {
+			if active := b.IsActive(mustParseTime(t, test.at)); active != test.expect {
+				t.Errorf("Expected blackout active at %s: %v, got %v", test.at, test.expect, active)
+			}
+		})
+	}
+}
+
+func TestNextRestartTime(t *testing.T) {
+	nightly := mustParseWindow(t, "0 22 * * *", "4h", "")
+	early := mustParseWindow(t, "0 1 * * *", "2h", "")
+	weekdays := mustParseWindow(t, "0 9 * * 1-5", "1h", "")
+	christmas := mustParseBlackout(t, "2026-12-24", "2026-12-26", "")
+	friday := mustParseBlackout(t, "2026-10-16T00:00:00Z", "2026-10-16T23:00:00Z", "")
+	never := mustParseWindow(t, "0 0 30 2 *", "1h", "")
+	longFreeze := mustParseBlackout(t, "2026-01-01", "2035-12-31", "")
+
+	tests := []struct {
+		name        string
+		at          string
+		windowSets  [][]*MaintenanceWindow
+		blackouts   []*Blackout
+		expect      string
+		expectFound bool
+	}{
+		{"no windows or blackouts", "2026-10-17T12:00:00Z", nil, nil, "2026-10-17T12:00:00Z", true},
+		{"empty window set allows any time", "2026-10-17T12:00:00Z", [][]*MaintenanceWindow{{}}, nil, "2026-10-17T12:00:00Z", true},
+		{"within window", "2026-10-17T23:00:00Z", [][]*MaintenanceWindow{{nightly}}, nil, "2026-10-17T23:00:00Z", true},
+		{"before window", "2026-10-17T12:00:00Z", [][]*MaintenanceWindow{{nightly}}, nil, "2026-10-17T22:00:00Z", true},
+		{"at window closing", "2026-10-18T02:00:00Z", [][]*MaintenanceWindow{{nightly}}, nil, "2026-10-18T22:00:00Z", true},
+		{"earliest of any window in a set", "2026-10-17T12:00:00Z", [][]*MaintenanceWindow{{early, nightly}}, nil, "2026-10-17T22:00:00Z", true},
+		{"overlap of all window sets", "2026-10-17T12:00:00Z", [][]*MaintenanceWindow{{nightly}, {early}}, nil, "2026-10-18T01:00:00Z", true},
+		{"weekend skipped", "2026-10-17T12:00:00Z", [][]*MaintenanceWindow{{weekdays}}, nil, "2026-10-19T09:00:00Z", true},
+		{"within blackout", "2026-12-25T12:00:00Z", nil, []*Blackout{christmas}, "2026-12-27T00:00:00Z", true},
+		{"window within blackout skipped", "2026-12-24T12:00:00Z", [][]*MaintenanceWindow{{nightly}}, []*Blackout{christmas}, "2026-12-27T00:00:00Z", true},
+		{"blackout ending outside window", "2026-10-16T10:00:00Z", [][]*MaintenanceWindow{{weekdays}}, []*Blackout{friday}, "2026-10-19T09:00:00Z", true},
+		{"blackout ending within window", "2026-10-16T10:00:00Z", [][]*MaintenanceWindow{{nightly}}, []*Blackout{friday}, "2026-10-16T23:00:00Z", true},
+		{"long blackout", "2026-10-17T12:00:00Z", [][]*MaintenanceWindow{{nightly}}, []*Blackout{longFreeze}, "2036-01-01T00:00:00Z", true},
+		{"window never opens", "2026-10-17T12:00:00Z", [][]*MaintenanceWindow{{never}}, nil, "", false},
+		{"no overlap of window sets", "2026-10-17T12:00:00Z", [][]*MaintenanceWindow{{nightly}, {weekdays}}, nil, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next, found := NextRestartTime(mustParseTime(t, test.at), test.windowSets, test.blackouts)
+			if found != test.expectFound {
+				t.Fatalf("Expected next restart time found: %v, got %v at %v", test.expectFound, found, next)
+			}
+			if !test.expectFound {
+				return
+			}
+
+			if expected := mustParseTime(t, test.expect); !next.Equal(expected) {
+				t.Errorf("Expected next restart time %v, got %v", expected, next)
+			}
+		})
+	}
+}